@@ -0,0 +1,23 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+)
+
+// isAdmin reports whether the request carries the admin API key. Admin
+// endpoints are disabled when no ADMIN_KEY is configured.
+func (cfg *apiConfig) isAdmin(r *http.Request) bool {
+	if cfg.AdminKey == "" {
+		return false
+	}
+
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(key), []byte(cfg.AdminKey)) == 1
+}
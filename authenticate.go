@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+var errTokenRevoked = errors.New("token has been revoked")
+var errInsufficientScope = errors.New("token does not grant the required scope")
+
+// authenticate validates the bearer JWT on the request, checks it against the
+// revocation list and returns the claims along with the numeric user id. Only
+// first-party tokens are accepted; use authenticateScoped for endpoints that
+// OAuth clients may call.
+func (cfg *apiConfig) authenticate(r *http.Request) (int, auth.TokenClaims, error) {
+	userId, claims, err := cfg.authenticateToken(r)
+	if err != nil {
+		return 0, auth.TokenClaims{}, err
+	}
+	if len(claims.Scopes) > 0 {
+		return 0, auth.TokenClaims{}, errInsufficientScope
+	}
+
+	return userId, claims, nil
+}
+
+// authenticateScoped accepts first-party tokens and OAuth tokens that were
+// granted the scope.
+func (cfg *apiConfig) authenticateScoped(r *http.Request, scope string) (int, auth.TokenClaims, error) {
+	userId, claims, err := cfg.authenticateToken(r)
+	if err != nil {
+		return 0, auth.TokenClaims{}, err
+	}
+	if !claims.HasScope(scope) {
+		return 0, auth.TokenClaims{}, errInsufficientScope
+	}
+
+	return userId, claims, nil
+}
+
+func (cfg *apiConfig) authenticateToken(r *http.Request) (int, auth.TokenClaims, error) {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+		return cfg.authenticateAPIKey(r)
+	}
+
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return 0, auth.TokenClaims{}, err
+	}
+
+	claims, err := auth.ParseJWT(tokenString, cfg.SecretString)
+	if err != nil {
+		return 0, auth.TokenClaims{}, err
+	}
+
+	revoked, err := cfg.DB.IsAccessTokenRevoked(claims.ID)
+	if err != nil {
+		return 0, auth.TokenClaims{}, err
+	}
+	if revoked {
+		return 0, auth.TokenClaims{}, errTokenRevoked
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, auth.TokenClaims{}, err
+	}
+
+	return userId, claims, nil
+}
+
+// authenticateAPIKey accepts a personal API key. Keys always carry scopes, so
+// they are never treated as first-party credentials.
+func (cfg *apiConfig) authenticateAPIKey(r *http.Request) (int, auth.TokenClaims, error) {
+	rawKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return 0, auth.TokenClaims{}, err
+	}
+
+	key, err := cfg.DB.GetAPIKeyByHash(auth.HashToken(rawKey))
+	if err != nil {
+		return 0, auth.TokenClaims{}, err
+	}
+
+	// Avoid rewriting the database on every request from a busy bot, and
+	// keep the write off the request path
+	now := time.Now().UTC()
+	if now.Sub(key.LastUsedAt) > time.Minute {
+		go func() {
+			err := cfg.DB.TouchAPIKey(key.ID, now)
+			if err != nil {
+				log.Printf("Could not record use of API key %s: %s", key.ID, err)
+			}
+		}()
+	}
+
+	return key.UserID, auth.TokenClaims{
+		ID:        "apikey:" + key.ID,
+		Subject:   strconv.Itoa(key.UserID),
+		ExpiresAt: key.ExpiresAt,
+		Scopes:    key.Scopes,
+	}, nil
+}
+
+// credentialRevoked reports whether the token or API key behind claims has
+// been revoked since it was accepted, for long-lived connections that
+// authenticated once. API keys are revoked by deleting them.
+func (cfg *apiConfig) credentialRevoked(claims auth.TokenClaims) (bool, error) {
+	keyId, isAPIKey := strings.CutPrefix(claims.ID, "apikey:")
+	if !isAPIKey {
+		return cfg.DB.IsAccessTokenRevoked(claims.ID)
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return false, err
+	}
+	keys, err := cfg.DB.GetAPIKeysByUser(userId)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		if key.ID == keyId {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// issueAccessToken creates a first-party JWT for the user and records its ID
+// so it can be revoked before it expires.
+func (cfg *apiConfig) issueAccessToken(userId int, expiresIn time.Duration) (string, error) {
+	return cfg.issueScopedAccessToken(userId, "", nil, expiresIn)
+}
+
+func (cfg *apiConfig) issueScopedAccessToken(userId int, clientId string, scopes []string, expiresIn time.Duration) (string, error) {
+	tokenId, err := auth.MakeTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	token, err := auth.MakeJWT(userId, tokenId, scopes, cfg.SecretString, expiresIn)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	err = cfg.DB.RecordAccessToken(db.AccessToken{
+		ID:        tokenId,
+		UserID:    userId,
+		ClientID:  clientId,
+		Scopes:    scopes,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(expiresIn),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
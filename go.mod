@@ -8,4 +8,13 @@ require (
 	golang.org/x/crypto v0.25.0
 )
 
-require github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
+require (
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/gorilla/websocket v1.5.3
+	github.com/rivo/uniseg v0.4.7
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.27.0
+	golang.org/x/text v0.16.0
+)
+
+require golang.org/x/sys v0.22.0 // indirect
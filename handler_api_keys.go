@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+const (
+	defaultAPIKeyLifetimeDays = 90
+	maxAPIKeyLifetimeDays     = 365
+)
+
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (cfg *apiConfig) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if strings.TrimSpace(params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "API key name is required")
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !supportedScopes[scope] {
+			respondWithError(w, http.StatusBadRequest, "Unknown scope "+scope)
+			return
+		}
+	}
+
+	if params.ExpiresInDays == 0 {
+		params.ExpiresInDays = defaultAPIKeyLifetimeDays
+	}
+	if params.ExpiresInDays < 0 || params.ExpiresInDays > maxAPIKeyLifetimeDays {
+		respondWithError(w, http.StatusBadRequest, "API keys must expire within 365 days")
+		return
+	}
+
+	id, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key")
+		return
+	}
+	secret, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key")
+		return
+	}
+	rawKey := "chirpy_" + secret
+
+	now := time.Now().UTC()
+	key := db.APIKey{
+		ID:        id[:16],
+		UserID:    userId,
+		Name:      params.Name,
+		Prefix:    rawKey[:15],
+		KeyHash:   auth.HashToken(rawKey),
+		Scopes:    params.Scopes,
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, params.ExpiresInDays),
+	}
+
+	err = cfg.DB.CreateAPIKey(key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key")
+		return
+	}
+
+	// The key itself is only ever returned here
+	response := apiKeyResponse(key)
+	response.Key = rawKey
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	dbKeys, err := cfg.DB.GetAPIKeysByUser(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys")
+		return
+	}
+
+	keys := []APIKey{}
+	for _, key := range dbKeys {
+		keys = append(keys, apiKeyResponse(key))
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	err = cfg.DB.DeleteAPIKey(userId, r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find API key")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func apiKeyResponse(key db.APIKey) APIKey {
+	response := APIKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+		ExpiresAt: key.ExpiresAt,
+	}
+	if !key.LastUsedAt.IsZero() {
+		lastUsed := key.LastUsedAt
+		response.LastUsedAt = &lastUsed
+	}
+	return response
+}
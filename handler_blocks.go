@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type UserRelation struct {
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (cfg *apiConfig) blockUser(w http.ResponseWriter, r *http.Request) {
+	userId, targetId, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.DB.BlockUser(userId, targetId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) unblockUser(w http.ResponseWriter, r *http.Request) {
+	userId, targetId, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.DB.UnblockUser(userId, targetId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unblock user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) getBlocks(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	blocks, err := cfg.DB.GetBlocks(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve blocks")
+		return
+	}
+
+	relations := []UserRelation{}
+	for _, block := range blocks {
+		relations = append(relations, UserRelation{
+			UserID:    block.BlockedID,
+			CreatedAt: block.CreatedAt,
+		})
+	}
+	respondWithRelations(w, relations)
+}
+
+func (cfg *apiConfig) muteUser(w http.ResponseWriter, r *http.Request) {
+	userId, targetId, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.DB.MuteUser(userId, targetId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) unmuteUser(w http.ResponseWriter, r *http.Request) {
+	userId, targetId, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.DB.UnmuteUser(userId, targetId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unmute user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) getMutes(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	mutes, err := cfg.DB.GetMutes(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve mutes")
+		return
+	}
+
+	relations := []UserRelation{}
+	for _, mute := range mutes {
+		relations = append(relations, UserRelation{
+			UserID:    mute.MutedID,
+			CreatedAt: mute.CreatedAt,
+		})
+	}
+	respondWithRelations(w, relations)
+}
+
+// relationTarget authenticates the caller and reads the {id} of the user
+// they want to block or mute, writing the error response itself on failure.
+func (cfg *apiConfig) relationTarget(w http.ResponseWriter, r *http.Request) (userId int, targetId int, ok bool) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return 0, 0, false
+	}
+
+	targetId, err = strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+		return 0, 0, false
+	}
+	if targetId == userId {
+		respondWithError(w, http.StatusBadRequest, "You can't do that to yourself")
+		return 0, 0, false
+	}
+
+	return userId, targetId, true
+}
+
+func respondWithRelations(w http.ResponseWriter, relations []UserRelation) {
+	sort.Slice(relations, func(i, j int) bool {
+		return relations[i].CreatedAt.Before(relations[j].CreatedAt)
+	})
+	respondWithJSON(w, http.StatusOK, relations)
+}
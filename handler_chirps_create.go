@@ -2,34 +2,64 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/linkpreview"
+	"github.com/Zmahl/chirpy/internal/validation"
+	"github.com/Zmahl/chirpy/internal/webhooks"
 )
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorID int    `json:"author_id"`
+	ID        int      `json:"id"`
+	Body      string   `json:"body"`
+	AuthorID  int      `json:"author_id"`
+	Author    *Author  `json:"author,omitempty"`
+	ReplyToID int      `json:"reply_to_id,omitempty"`
+	MediaIDs  []string `json:"media_ids,omitempty"`
+
+	LinkPreviews []LinkPreview `json:"link_previews,omitempty"`
+
+	Status    string     `json:"status,omitempty"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+
+	Visibility string `json:"visibility,omitempty"`
 }
 
+// maxScheduleAhead is how far in the future a chirp can be scheduled.
+const maxScheduleAhead = 365 * 24 * time.Hour
+
 func (cfg *apiConfig) postChirp(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string   `json:"body"`
+		ReplyToID int      `json:"reply_to_id"`
+		MediaIDs  []string `json:"media_ids"`
+		// Draft chirps are only visible to their author until published
+		Draft     bool       `json:"draft"`
+		PublishAt *time.Time `json:"publish_at"`
+		// Visibility is public, followers or mentioned
+		Visibility string `json:"visibility"`
 	}
 
-	tokenString, err := auth.GetBearerToken(r.Header)
+	authNumId, _, err := cfg.authenticateScoped(r, scopeChirpsWrite)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Can't post a chirp while not logged in")
 		return
 	}
 
-	authorId, err := auth.ValidateJWT(tokenString, cfg.SecretString)
+	author, err := cfg.DB.GetUserByID(authNumId)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Can't post a chirp while not logged in")
+		return
+	}
+	if !author.IsVerified {
+		respondWithError(w, http.StatusForbidden, "Verify your email before posting chirps")
+		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -41,26 +71,111 @@ func (cfg *apiConfig) postChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(params.Body) > 140 {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+	entitlements := cfg.Plans.For(author)
+	params.Body, err = cfg.ChirpValidator.Validate(params.Body, entitlements.MaxChirpLength)
+	if err != nil {
+		respondWithTextValidationError(w, "Chirp", err)
 		return
 	}
 
-	authNumId, err := strconv.Atoi(authorId)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+	if params.Visibility == "" {
+		params.Visibility = db.ChirpVisibilityPublic
+	}
+	if !chirpVisibilities[params.Visibility] {
+		respondWithError(w, http.StatusBadRequest, "visibility must be public, followers or mentioned")
+		return
+	}
+
+	status := db.ChirpStatusPublished
+	publishAt := time.Time{}
+	if params.Draft {
+		status = db.ChirpStatusDraft
+	}
+	if params.PublishAt != nil {
+		if params.Draft {
+			respondWithError(w, http.StatusBadRequest, "A chirp can't be both a draft and scheduled")
+			return
+		}
+		if !entitlements.ScheduledPosts {
+			respondWithError(w, http.StatusForbidden, "Scheduling chirps requires Chirpy Red")
+			return
+		}
+		untilPublish := time.Until(*params.PublishAt)
+		if untilPublish <= 0 || untilPublish > maxScheduleAhead {
+			respondWithError(w, http.StatusBadRequest, "publish_at must be in the future and within a year")
+			return
+		}
+		status = db.ChirpStatusScheduled
+		publishAt = params.PublishAt.UTC()
+	}
+
+	if params.ReplyToID != 0 {
+		parent, err := cfg.DB.GetChirp(params.ReplyToID)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Chirp being replied to does not exist")
+			return
+		}
+		audience, err := cfg.DB.GetAudience(authNumId)
+		if err != nil || !audience.CanView(parent) {
+			respondWithError(w, http.StatusBadRequest, "Chirp being replied to does not exist")
+			return
+		}
+	}
+
+	if len(params.MediaIDs) > maxMediaPerChirp {
+		respondWithError(w, http.StatusBadRequest, "Too many media attachments")
+		return
+	}
+
+	limitKey := strconv.Itoa(authNumId)
+	postedAt := time.Now()
+	allowed, wait := cfg.ChirpLimiter.Allow(limitKey, entitlements.ChirpsPerHour, time.Hour, postedAt)
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		respondWithError(w, http.StatusTooManyRequests, "You're posting chirps too quickly")
 		return
 	}
 
-	chirp, err := cfg.DB.CreateChirp(authNumId, cleanseBody(params.Body))
+	body := cleanseBody(params.Body)
+	chirp, err := cfg.DB.CreateChirp(db.Chirp{
+		Body:      body,
+		AuthorID:  authNumId,
+		ReplyToID: params.ReplyToID,
+		MediaIDs:  params.MediaIDs,
+		URLs:      linkpreview.ExtractURLs(body),
+		Status:    status,
+		PublishAt: publishAt,
+
+		Visibility:   params.Visibility,
+		MentionedIDs: cfg.mentionedUsers(authNumId, body),
+	})
 	if err != nil {
+		// Nothing was posted, so it shouldn't count against the limit
+		cfg.ChirpLimiter.Refund(limitKey, postedAt)
 		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	response := cfg.chirpResponse(chirp)
+	if chirp.Published() {
+		cfg.Webhooks.Publish(webhooks.EventChirpCreated, authNumId, response)
+	}
+
+	respondWithJSON(w, 201, response)
+}
+
+// respondWithTextValidationError explains a validation.ChirpValidator error.
+// noun is what was being posted, such as "Chirp" or "Message".
+func respondWithTextValidationError(w http.ResponseWriter, noun string, err error) {
+	switch {
+	case errors.Is(err, validation.ErrTooLong):
+		respondWithError(w, http.StatusBadRequest, noun+" is too long")
+	case errors.Is(err, validation.ErrEmpty):
+		respondWithError(w, http.StatusBadRequest, noun+" can't be empty")
+	case errors.Is(err, validation.ErrControlCharacter):
+		respondWithError(w, http.StatusBadRequest, noun+" can't contain control characters")
+	default:
+		respondWithError(w, http.StatusBadRequest, noun+" must be valid UTF-8 text")
 	}
-	respondWithJSON(w, 201, Chirp{
-		ID:       chirp.ID,
-		Body:     chirp.Body,
-		AuthorID: authNumId,
-	})
 }
 
 func respondWithError(w http.ResponseWriter, code int, msg string) {
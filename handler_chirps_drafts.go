@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/webhooks"
+)
+
+// getDrafts lists the caller's drafts and scheduled chirps.
+func (cfg *apiConfig) getDrafts(w http.ResponseWriter, r *http.Request) {
+	authNumId, _, err := cfg.authenticateScoped(r, scopeChirpsRead)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	dbChirps, err := cfg.DB.GetUnpublishedChirps(authNumId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve drafts")
+		return
+	}
+
+	chirps := cfg.chirpResponses(dbChirps)
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].ID < chirps[j].ID
+	})
+
+	respondWithJSON(w, http.StatusOK, chirps)
+}
+
+// publishChirp publishes a draft or scheduled chirp right away.
+func (cfg *apiConfig) publishChirp(w http.ResponseWriter, r *http.Request) {
+	authNumId, _, err := cfg.authenticateScoped(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	chirpNumId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Chirp id is not a number")
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(chirpNumId)
+	if err != nil || chirp.AuthorID != authNumId {
+		respondWithError(w, http.StatusNotFound, "Chirp does not exist")
+		return
+	}
+
+	// The scheduler may publish the chirp at any moment, so rely on
+	// PublishChirp rather than checking the status here
+	chirp, err = cfg.DB.PublishChirp(chirpNumId, time.Now())
+	if errors.Is(err, db.ErrChirpPublished) {
+		respondWithError(w, http.StatusConflict, "Chirp is already published")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't publish chirp")
+		return
+	}
+	response := cfg.chirpResponse(chirp)
+	cfg.Webhooks.Publish(webhooks.EventChirpCreated, authNumId, response)
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// scheduledChirpPublished sends the webhooks postChirp would have sent had
+// the chirp been published immediately.
+func (cfg *apiConfig) scheduledChirpPublished(chirp db.Chirp) {
+	cfg.Webhooks.Publish(webhooks.EventChirpCreated, chirp.AuthorID, cfg.chirpResponse(chirp))
+}
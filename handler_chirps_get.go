@@ -1,11 +1,17 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
+
+	"github.com/Zmahl/chirpy/internal/db"
 )
 
+// getChirps lists the chirps the caller can see, optionally filtered by
+// author_id and searched with q, a case-insensitive substring.
 func (cfg *apiConfig) getChirps(w http.ResponseWriter, r *http.Request) {
 	dbChirps, err := cfg.DB.GetChirps()
 	if err != nil {
@@ -13,8 +19,34 @@ func (cfg *apiConfig) getChirps(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chirps := []Chirp{}
+	audience, err := cfg.viewerAudience(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
 	authorId := r.URL.Query().Get("author_id")
+	query := strings.ToLower(r.URL.Query().Get("q"))
+	visible := []db.Chirp{}
+	for _, dbChirp := range dbChirps {
+		if !audience.CanView(dbChirp) {
+			continue
+		}
+		// Drafts are listed separately, even for their author
+		if !dbChirp.Published() {
+			continue
+		}
+		// Muted authors are left out of timelines, but asking for their
+		// chirps by author_id still works
+		if authorId == "" && audience.Hides(dbChirp) {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(dbChirp.Body), query) {
+			continue
+		}
+		visible = append(visible, dbChirp)
+	}
+
+	selected := []db.Chirp{}
 
 	if authorId != "" {
 		authNumId, err := strconv.Atoi(authorId)
@@ -22,22 +54,15 @@ func (cfg *apiConfig) getChirps(w http.ResponseWriter, r *http.Request) {
 			respondWithError(w, http.StatusBadRequest, "Could not retrieve chirps from that author")
 			return
 		}
-		for _, dbChirp := range dbChirps {
+		for _, dbChirp := range visible {
 			if dbChirp.AuthorID == authNumId {
-				chirps = append(chirps, Chirp{
-					ID:   dbChirp.ID,
-					Body: dbChirp.Body,
-				})
+				selected = append(selected, dbChirp)
 			}
 		}
 	} else {
-		for _, dbChirp := range dbChirps {
-			chirps = append(chirps, Chirp{
-				ID:   dbChirp.ID,
-				Body: dbChirp.Body,
-			})
-		}
+		selected = visible
 	}
+	chirps := cfg.chirpResponses(selected)
 
 	sort.Slice(chirps, func(i, j int) bool {
 		return chirps[i].ID < chirps[j].ID
@@ -53,26 +78,96 @@ func (cfg *apiConfig) getChirps(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) getSingleChirp(w http.ResponseWriter, r *http.Request) {
-	dbChirps, err := cfg.DB.GetChirps()
+	desiredId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Id is not a number")
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(desiredId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Chirp does not exist")
+		return
+	}
+
+	audience, err := cfg.viewerAudience(r)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
+		return
+	}
+	if !audience.CanView(chirp) {
+		respondWithError(w, http.StatusNotFound, "Chirp does not exist")
+		return
 	}
 
-	desiredChirp := Chirp{}
-	desiredId, err := strconv.Atoi(r.PathValue("id"))
+	respondWithJSON(w, http.StatusOK, cfg.chirpResponse(chirp))
+}
+
+// chirpResponses converts chirps to their API representation, attaching
+// their authors and any link previews that have been fetched.
+func (cfg *apiConfig) chirpResponses(dbChirps []db.Chirp) []Chirp {
+	urls := []string{}
+	authorIds := []int{}
+	for _, dbChirp := range dbChirps {
+		urls = append(urls, dbChirp.URLs...)
+		authorIds = append(authorIds, dbChirp.AuthorID)
+	}
+	authors, err := cfg.DB.GetUsers(authorIds)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Id is not a number")
+		log.Printf("Couldn't read chirp authors: %s", err)
 	}
-	if desiredId > len(dbChirps) {
-		respondWithError(w, http.StatusNotFound, "Chirp Id does not exist yet")
+	previews := map[string]db.LinkPreview{}
+	if len(urls) > 0 {
+		previews, err = cfg.DB.GetLinkPreviews(urls)
+		if err != nil {
+			log.Printf("Couldn't read link previews: %s", err)
+		}
 	}
-	for _, chirp := range dbChirps {
-		if chirp.ID == desiredId {
-			desiredChirp.ID = chirp.ID
-			desiredChirp.Body = chirp.Body
-			break
+
+	chirps := make([]Chirp, 0, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		chirp := Chirp{
+			ID:        dbChirp.ID,
+			Body:      dbChirp.Body,
+			AuthorID:  dbChirp.AuthorID,
+			ReplyToID: dbChirp.ReplyToID,
+			MediaIDs:  dbChirp.MediaIDs,
+			Status:    dbChirp.Status,
+
+			Visibility: dbChirp.Visibility,
+		}
+		if author, exists := authors[dbChirp.AuthorID]; exists {
+			chirp.Author = authorResponse(author)
+		}
+		if chirp.Visibility == "" {
+			chirp.Visibility = db.ChirpVisibilityPublic
+		}
+		if chirp.Status == "" {
+			chirp.Status = db.ChirpStatusPublished
+		}
+		if !dbChirp.PublishAt.IsZero() {
+			publishAt := dbChirp.PublishAt
+			chirp.PublishAt = &publishAt
 		}
+		for _, url := range dbChirp.URLs {
+			preview, exists := previews[url]
+			if !exists || preview.Error != "" {
+				continue
+			}
+			chirp.LinkPreviews = append(chirp.LinkPreviews, LinkPreview{
+				URL:         preview.URL,
+				Title:       preview.Title,
+				Description: preview.Description,
+				Image:       preview.Image,
+				SiteName:    preview.SiteName,
+			})
+		}
+		chirps = append(chirps, chirp)
 	}
 
-	respondWithJSON(w, http.StatusOK, desiredChirp)
+	return chirps
+}
+
+func (cfg *apiConfig) chirpResponse(dbChirp db.Chirp) Chirp {
+	return cfg.chirpResponses([]db.Chirp{dbChirp})[0]
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/pubsub"
+)
+
+const streamHeartbeatInterval = 30 * time.Second
+
+// streamChirps pushes chirp.created and chirp.deleted events as Server-Sent
+// Events. Each connection waits on its own broker channel, so idle clients
+// cost nothing until something is published. Only chirps the caller could
+// fetch from GET /api/chirps are streamed.
+func (cfg *apiConfig) streamChirps(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	authNumId := 0
+	authorId := r.URL.Query().Get("author_id")
+	if authorId != "" {
+		var err error
+		authNumId, err = strconv.Atoi(authorId)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Could not retrieve chirps from that author")
+			return
+		}
+	}
+
+	viewerId, claims, err := cfg.authenticateScoped(r, scopeChirpsRead)
+	if err != nil {
+		viewerId = 0
+	}
+	audience, err := cfg.newLiveAudience(viewerId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open stream")
+		return
+	}
+	filter := func(event pubsub.Event) bool {
+		if authNumId != 0 && event.Chirp.AuthorID != authNumId {
+			return false
+		}
+		if authNumId == 0 && audience.Hides(event.Chirp) {
+			return false
+		}
+		return audience.CanView(event.Chirp)
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("last_event_id")
+	}
+
+	sub, missed, complete := cfg.Broker.Subscribe(lastEventId, filter)
+	defer cfg.Broker.Unsubscribe(sub)
+
+	// End the stream if the viewer deletes their account or their token
+	// expires. Anonymous streams wait on nil channels, which never receive
+	var accountDeleted chan pubsub.Event
+	var expired <-chan time.Time
+	if viewerId != 0 {
+		expiry := time.NewTimer(time.Until(claims.ExpiresAt))
+		defer expiry.Stop()
+		expired = expiry.C
+
+		deletedSub, _, _ := cfg.Notifications.Subscribe("", func(event pubsub.Event) bool {
+			return event.Type == pubsub.EventUserDeleted && event.UserID == viewerId
+		})
+		defer cfg.Notifications.Unsubscribe(deletedSub)
+		accountDeleted = deletedSub.C
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// Tell the client to refetch GET /api/chirps when we can't replay
+	// everything it missed
+	if !complete {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range missed {
+		writeStreamEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-accountDeleted:
+			return
+		case <-expired:
+			return
+		case <-heartbeat.C:
+			// Logging out or resetting the password revokes the token, which
+			// must also cut off private chirps on open streams
+			if viewerId != 0 {
+				revoked, err := cfg.credentialRevoked(claims)
+				if err != nil || revoked {
+					return
+				}
+			}
+			audience.Refresh()
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case event, open := <-sub.C:
+			// The broker drops subscribers that fall too far behind; closing
+			// the response makes the client reconnect with Last-Event-ID
+			if !open {
+				return
+			}
+			writeStreamEvent(w, event)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, event pubsub.Event) {
+	chirp := Chirp{
+		ID:       event.Chirp.ID,
+		AuthorID: event.Chirp.AuthorID,
+	}
+	if event.Type != pubsub.EventChirpDeleted {
+		chirp.Body = event.Chirp.Body
+	}
+
+	data, err := json.Marshal(chirp)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/linkpreview"
+)
+
+func (cfg *apiConfig) editChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	authNumId, _, err := cfg.authenticateScoped(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	author, err := cfg.DB.GetUserByID(authNumId)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	entitlements := cfg.Plans.For(author)
+
+	chirpNumId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Chirp id is not a number")
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(chirpNumId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Chirp does not exist")
+		return
+	}
+	if chirp.AuthorID != authNumId {
+		respondWithError(w, http.StatusForbidden, "User cannot edit this chirp")
+		return
+	}
+	// Drafts and scheduled chirps can always be revised before they go out
+	if chirp.Published() && !entitlements.EditChirps {
+		respondWithError(w, http.StatusForbidden, "Editing chirps requires Chirpy Red")
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	params.Body, err = cfg.ChirpValidator.Validate(params.Body, entitlements.MaxChirpLength)
+	if err != nil {
+		respondWithTextValidationError(w, "Chirp", err)
+		return
+	}
+
+	body := cleanseBody(params.Body)
+	chirp, err = cfg.DB.UpdateChirp(chirpNumId, body, linkpreview.ExtractURLs(body), cfg.mentionedUsers(authNumId, body), entitlements.EditChirps)
+	if errors.Is(err, db.ErrChirpPublished) {
+		respondWithError(w, http.StatusForbidden, "Editing chirps requires Chirpy Red")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.chirpResponse(chirp))
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+const (
+	maxConversationParticipants = 20
+	maxMessageLength            = 2000
+	defaultMessagePageSize      = 50
+	maxMessagePageSize          = 100
+)
+
+type Conversation struct {
+	ID             string      `json:"id"`
+	ParticipantIDs []int       `json:"participant_ids"`
+	LastRead       map[int]int `json:"last_read"`
+	UnreadCount    int         `json:"unread_count"`
+	LastMessage    *Message    `json:"last_message,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+	LastMessageAt  time.Time   `json:"last_message_at"`
+}
+
+type Message struct {
+	ID             int       `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// createConversation starts a conversation with the given users, or returns
+// the existing one if these exact participants already have one.
+func (cfg *apiConfig) createConversation(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ParticipantIDs []int `json:"participant_ids"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	participants := []int{userId}
+	for _, participantId := range params.ParticipantIDs {
+		if participantId != userId {
+			participants = append(participants, participantId)
+		}
+	}
+	if len(participants) < 2 {
+		respondWithError(w, http.StatusBadRequest, "A conversation needs at least one other participant")
+		return
+	}
+	if len(participants) > maxConversationParticipants {
+		respondWithError(w, http.StatusBadRequest, "Too many participants")
+		return
+	}
+
+	id, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create conversation")
+		return
+	}
+
+	conversation, err := cfg.DB.GetOrCreateConversation(id, participants)
+	if errors.Is(err, db.ErrBlocked) {
+		respondWithError(w, http.StatusForbidden, "You can't message these users")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+
+	status := http.StatusOK
+	if conversation.ID == id {
+		status = http.StatusCreated
+	}
+	respondWithJSON(w, status, conversationResponse(conversation, 0, nil))
+}
+
+func (cfg *apiConfig) getConversations(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Conversations []Conversation `json:"conversations"`
+		UnreadCount   int            `json:"unread_count"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	conversations, err := cfg.DB.GetConversations(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve conversations")
+		return
+	}
+	unread, err := cfg.DB.UnreadMessageCounts(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve conversations")
+		return
+	}
+
+	lastMessages, err := cfg.DB.LastMessages(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve conversations")
+		return
+	}
+
+	resp := response{
+		Conversations: []Conversation{},
+	}
+	for _, conversation := range conversations {
+		var last *db.Message
+		if message, exists := lastMessages[conversation.ID]; exists {
+			last = &message
+		}
+
+		resp.Conversations = append(resp.Conversations, conversationResponse(conversation, unread[conversation.ID], last))
+		resp.UnreadCount += unread[conversation.ID]
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// getMessages pages backwards through a conversation. Each page is in
+// chronological order; pass next_before as ?before= for older messages.
+func (cfg *apiConfig) getMessages(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Messages   []Message `json:"messages"`
+		NextBefore int       `json:"next_before,omitempty"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	conversation, err := cfg.DB.GetConversation(r.PathValue("id"), userId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find conversation")
+		return
+	}
+
+	limit := defaultMessagePageSize
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxMessagePageSize {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+	}
+	before := 0
+	if beforeParam := r.URL.Query().Get("before"); beforeParam != "" {
+		before, err = strconv.Atoi(beforeParam)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid before cursor")
+			return
+		}
+	}
+
+	dbMessages, err := cfg.DB.GetMessages(conversation.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve messages")
+		return
+	}
+	if before != 0 {
+		end := 0
+		for end < len(dbMessages) && dbMessages[end].ID < before {
+			end++
+		}
+		dbMessages = dbMessages[:end]
+	}
+
+	resp := response{
+		Messages: []Message{},
+	}
+	if len(dbMessages) > limit {
+		dbMessages = dbMessages[len(dbMessages)-limit:]
+		resp.NextBefore = dbMessages[0].ID
+	}
+	for _, message := range dbMessages {
+		resp.Messages = append(resp.Messages, messageResponse(message))
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) sendMessage(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	body, err := cfg.ChirpValidator.Validate(params.Body, maxMessageLength)
+	if err != nil {
+		respondWithTextValidationError(w, "Message", err)
+		return
+	}
+
+	message, err := cfg.DB.CreateMessage(r.PathValue("id"), userId, body)
+	if errors.Is(err, db.ErrNotParticipant) {
+		respondWithError(w, http.StatusNotFound, "Could not find conversation")
+		return
+	}
+	if errors.Is(err, db.ErrBlocked) {
+		respondWithError(w, http.StatusForbidden, "You can't message these users")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send message")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, messageResponse(message))
+}
+
+// markConversationRead records a read receipt up to message_id, or up to the
+// newest message if it is omitted.
+func (cfg *apiConfig) markConversationRead(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MessageID int `json:"message_id"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		err = json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+			return
+		}
+	}
+
+	conversation, err := cfg.DB.MarkConversationRead(r.PathValue("id"), userId, params.MessageID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find conversation")
+		return
+	}
+	unread, err := cfg.DB.UnreadMessageCounts(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve conversation")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, conversationResponse(conversation, unread[conversation.ID], nil))
+}
+
+func conversationResponse(conversation db.Conversation, unread int, last *db.Message) Conversation {
+	lastRead := conversation.LastRead
+	if lastRead == nil {
+		lastRead = map[int]int{}
+	}
+
+	response := Conversation{
+		ID:             conversation.ID,
+		ParticipantIDs: conversation.ParticipantIDs,
+		LastRead:       lastRead,
+		UnreadCount:    unread,
+		CreatedAt:      conversation.CreatedAt,
+		LastMessageAt:  conversation.LastMessageAt,
+	}
+	if last != nil {
+		message := messageResponse(*last)
+		response.LastMessage = &message
+	}
+	return response
+}
+
+func messageResponse(message db.Message) Message {
+	return Message{
+		ID:             message.ID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		Body:           message.Body,
+		CreatedAt:      message.CreatedAt,
+	}
+}
@@ -1,20 +1,15 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"strconv"
 
-	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/webhooks"
 )
 
 func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
-		return
-	}
-
-	authId, err := auth.ValidateJWT(tokenString, cfg.SecretString)
+	authNumId, _, err := cfg.authenticateScoped(r, scopeChirpsWrite)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
 		return
@@ -26,12 +21,6 @@ func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authNumId, err := strconv.Atoi(authId)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not validate author id")
-		return
-	}
-
 	chirpNumId, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Chirp id is not a number")
@@ -41,7 +30,21 @@ func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
 	for _, chirp := range chirps {
 		if chirp.ID == chirpNumId {
 			if chirp.AuthorID == authNumId {
-				cfg.DB.DeleteChirp(chirpNumId)
+				blobKeys, err := cfg.DB.DeleteChirp(chirpNumId)
+				if err != nil {
+					respondWithError(w, http.StatusInternalServerError, "Could not delete chirp")
+					return
+				}
+				err = cfg.DB.DeleteBlobs(blobKeys, cfg.Blobs.Delete)
+				if err != nil {
+					log.Printf("Couldn't delete blobs of deleted chirp %d: %s", chirpNumId, err)
+				}
+				if chirp.Published() {
+					cfg.Webhooks.Publish(webhooks.EventChirpDeleted, authNumId, Chirp{
+						ID:       chirp.ID,
+						AuthorID: chirp.AuthorID,
+					})
+				}
 			} else {
 				respondWithError(w, http.StatusForbidden, "User cannot delete this chirp")
 				return
@@ -0,0 +1,19 @@
+package main
+
+import "net/http"
+
+func (cfg *apiConfig) getEntitlements(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticateScoped(r, scopeProfileRead)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.Plans.For(user))
+}
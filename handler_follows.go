@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// followUser follows another user. Following a private account sends them a
+// request instead, and the response status is "pending" until they approve.
+func (cfg *apiConfig) followUser(w http.ResponseWriter, r *http.Request) {
+	followerId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	followeeId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+	if followeeId == followerId {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself")
+		return
+	}
+
+	follow, created, err := cfg.DB.FollowUser(followerId, followeeId)
+	if errors.Is(err, db.ErrBlocked) {
+		respondWithError(w, http.StatusForbidden, "You can't follow this user")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+	if created {
+		notificationType := db.NotificationFollow
+		if !follow.Approved() {
+			notificationType = db.NotificationFollowRequest
+		}
+		cfg.notify(db.Notification{
+			UserID:  followeeId,
+			Type:    notificationType,
+			ActorID: followerId,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, followResponse(follow))
+}
+
+func (cfg *apiConfig) unfollowUser(w http.ResponseWriter, r *http.Request) {
+	followerId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	followeeId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	err = cfg.DB.UnfollowUser(followerId, followeeId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) getFollowRequests(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	pending, err := cfg.DB.GetPendingFollows(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve follow requests")
+		return
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+
+	requests := []Follow{}
+	for _, follow := range pending {
+		requests = append(requests, followResponse(follow))
+	}
+
+	respondWithJSON(w, http.StatusOK, requests)
+}
+
+func (cfg *apiConfig) approveFollowRequest(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	followerId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	err = cfg.DB.ApproveFollow(followerId, userId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find follow request")
+		return
+	}
+	cfg.notify(db.Notification{
+		UserID:  followerId,
+		Type:    db.NotificationFollowApproved,
+		ActorID: userId,
+	})
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// rejectFollowRequest removes a pending request. It also works on approved
+// follows, which lets a private account remove an existing follower.
+func (cfg *apiConfig) rejectFollowRequest(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	followerId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	err = cfg.DB.UnfollowUser(followerId, userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove follow request")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// updatePrivacy makes the account private or public.
+func (cfg *apiConfig) updatePrivacy(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		IsPrivate bool `json:"is_private"`
+	}
+	type response struct {
+		IsPrivate bool `json:"is_private"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	err = cfg.DB.SetUserPrivate(userId, params.IsPrivate)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update privacy")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		IsPrivate: params.IsPrivate,
+	})
+}
+
+func followResponse(follow db.Follow) Follow {
+	status := follow.Status
+	if follow.Approved() {
+		status = db.FollowApproved
+	}
+	return Follow{
+		FollowerID: follow.FollowerID,
+		FolloweeID: follow.FolloweeID,
+		Status:     status,
+		CreatedAt:  follow.CreatedAt,
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"log"
 	"net/http"
 	"time"
 
-	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
 )
 
 func (cfg *apiConfig) userLogin(w http.ResponseWriter, r *http.Request) {
@@ -26,50 +27,86 @@ func (cfg *apiConfig) userLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Lockouts are keyed on the submitted email rather than the user so they
+	// behave the same whether or not the account exists
+	throttleKeys := []string{accountThrottleKey(params.Email), ipThrottleKey(r)}
+	wait, err := cfg.loginLockedFor(throttleKeys...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts")
+		return
+	}
+	if wait > 0 {
+		respondWithLockout(w, wait)
+		return
+	}
+
 	desiredUser, err := cfg.DB.GetUser(params.Email)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	if desiredUser.ID == 0 {
-		respondWithError(w, http.StatusUnauthorized, "User with that email does not exist")
+	needsRehash, err := cfg.checkPasswordUniform(params.Password, desiredUser.Password)
+	if err != nil {
+		cfg.recordLoginFailure(throttleKeys...)
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, string(desiredUser.Password))
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Wrong password")
+	// Upgrade the stored hash now that we have the plaintext password
+	if needsRehash {
+		hashedPassword, err := cfg.Hasher.Hash(params.Password)
+		if err == nil {
+			err = cfg.DB.UpdatePassword(desiredUser.ID, hashedPassword)
+		}
+		if err != nil {
+			log.Printf("Could not rehash password for user %d: %s", desiredUser.ID, err)
+		}
+	}
+
+	if desiredUser.TOTPEnabled {
+		cfg.respondWithMFAChallenge(w, desiredUser)
 		return
 	}
 
+	cfg.respondWithLogin(w, desiredUser, params.Expire)
+}
+
+// respondWithLogin issues an access token and a new refresh token for a user
+// who has fully authenticated. The account's failed attempts are only
+// cleared here, so knowing the password alone doesn't reset the lockout on
+// guessing TOTP codes.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, user db.User, expire int) {
+	cfg.DB.ClearLoginAttempts(accountThrottleKey(user.Email))
+
 	defaultExpiration := 60 * 60
-	if params.Expire == 0 {
-		params.Expire = defaultExpiration
-	} else if params.Expire > defaultExpiration {
-		params.Expire = defaultExpiration
+	if expire == 0 {
+		expire = defaultExpiration
+	} else if expire > defaultExpiration {
+		expire = defaultExpiration
 	}
 
-	token, err := auth.MakeJWT(desiredUser.ID, cfg.SecretString, time.Duration(params.Expire)*time.Second)
+	token, err := cfg.issueAccessToken(user.ID, time.Duration(expire)*time.Second)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create JWT")
+		return
 	}
 
 	b := make([]byte, 32)
 	_, err = rand.Read(b)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "could not generate refresh token")
+		return
 	}
 	refreshToken := hex.EncodeToString(b)
-	err = cfg.DB.RefreshToken(desiredUser.ID, refreshToken)
+	err = cfg.DB.RefreshToken(user.ID, refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "could not write refresh token")
+		return
 	}
-	respondWithJSON(w, http.StatusOK, User{
-		ID:           desiredUser.ID,
-		Email:        desiredUser.Email,
+	respondWithJSON(w, http.StatusOK, LoginResponse{
+		PrivateUser:  privateUserResponse(user),
 		Token:        token,
 		RefreshToken: refreshToken,
-		IsRed:        desiredUser.IsRed,
 	})
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+)
+
+func (cfg *apiConfig) logout(w http.ResponseWriter, r *http.Request) {
+	userId, claims, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	err = cfg.DB.RevokeAccessToken(userId, claims.ID, claims.ExpiresAt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke access token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) logoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	err = cfg.DB.RevokeAllUserTokens(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke tokens")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/media"
+)
+
+const maxMediaPerChirp = 4
+
+const (
+	// cachePublic is for media anyone can see. The bytes behind a URL never
+	// change, so caches can keep them for good.
+	cachePublic = "public, max-age=31536000, immutable"
+	// cachePrivate is for media only some viewers can see. Access can be
+	// lost at any time, so browsers must check back every time.
+	cachePrivate = "private, no-cache"
+)
+
+type Media struct {
+	ID           string `json:"id"`
+	ContentType  string `json:"content_type"`
+	Size         int    `json:"size"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+// uploadMedia accepts a multipart upload in the "file" field. The returned
+// id can be passed in media_ids when posting a chirp.
+func (cfg *apiConfig) uploadMedia(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticateScoped(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userId)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	if !user.IsVerified {
+		respondWithError(w, http.StatusForbidden, "Verify your email before uploading media")
+		return
+	}
+
+	// Leave room for the multipart framing around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxMediaBytes+64*1024)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Expected a multipart upload with a file field")
+		return
+	}
+	defer file.Close()
+	if header.Size > cfg.MaxMediaBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, cfg.MaxMediaBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read upload")
+		return
+	}
+	if int64(len(data)) > cfg.MaxMediaBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+
+	processed, err := media.Process(data)
+	if errors.Is(err, media.ErrUnsupportedType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Only JPEG, PNG and GIF images are supported")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store media")
+		return
+	}
+	dbMedia := db.Media{
+		ID:                   id,
+		OwnerID:              userId,
+		ContentType:          processed.ContentType,
+		Size:                 len(processed.Data),
+		Width:                processed.Width,
+		Height:               processed.Height,
+		ThumbnailContentType: processed.ThumbnailContentType,
+		CreatedAt:            time.Now().UTC(),
+	}
+	dbMedia, err = cfg.DB.CreateMedia(dbMedia, func(media *db.Media) error {
+		var err error
+		media.BlobKey, err = cfg.Blobs.Put(processed.Data)
+		if err != nil {
+			return err
+		}
+		media.ThumbnailKey, err = cfg.Blobs.Put(processed.Thumbnail)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store media")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, mediaResponse(dbMedia))
+}
+
+func (cfg *apiConfig) getMedia(w http.ResponseWriter, r *http.Request) {
+	dbMedia, cacheControl, ok := cfg.viewableMedia(r)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Could not find media")
+		return
+	}
+
+	cfg.serveBlob(w, r, dbMedia.BlobKey, dbMedia.ContentType, dbMedia.CreatedAt, cacheControl)
+}
+
+func (cfg *apiConfig) getMediaThumbnail(w http.ResponseWriter, r *http.Request) {
+	dbMedia, cacheControl, ok := cfg.viewableMedia(r)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Could not find media")
+		return
+	}
+
+	cfg.serveBlob(w, r, dbMedia.ThumbnailKey, dbMedia.ThumbnailContentType, dbMedia.CreatedAt, cacheControl)
+}
+
+// viewableMedia looks up the media in the path and decides whether the caller
+// may see it. Avatars are public. Media attached to a chirp follows the
+// chirp, so it disappears when the chirp is deleted. Media that isn't used
+// anywhere yet is only visible to its uploader. Only media anyone could see
+// is cached publicly.
+func (cfg *apiConfig) viewableMedia(r *http.Request) (dbMedia db.Media, cacheControl string, ok bool) {
+	dbMedia, use, err := cfg.DB.GetMediaUse(r.PathValue("id"))
+	if err != nil {
+		return db.Media{}, "", false
+	}
+	if use.Avatar {
+		return dbMedia, cachePublic, true
+	}
+
+	audience, err := cfg.viewerAudience(r)
+	if err != nil {
+		return db.Media{}, "", false
+	}
+	if dbMedia.ChirpID == 0 {
+		if audience.ViewerID == 0 || audience.ViewerID != dbMedia.OwnerID {
+			return db.Media{}, "", false
+		}
+		return dbMedia, cachePrivate, true
+	}
+	if !use.HasChirp || !audience.CanView(use.Chirp) {
+		return db.Media{}, "", false
+	}
+
+	anonymous := db.Audience{Private: audience.Private}
+	if anonymous.CanView(use.Chirp) {
+		return dbMedia, cachePublic, true
+	}
+	return dbMedia, cachePrivate, true
+}
+
+// serveBlob serves a blob with the given caching policy. Blobs are content
+// addressed and never change, so the key doubles as a strong ETag.
+func (cfg *apiConfig) serveBlob(w http.ResponseWriter, r *http.Request, key string, contentType string, modified time.Time, cacheControl string) {
+	blob, err := cfg.Blobs.Open(key)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find media")
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", `"`+key+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", modified, blob)
+}
+
+func mediaResponse(dbMedia db.Media) Media {
+	return Media{
+		ID:           dbMedia.ID,
+		ContentType:  dbMedia.ContentType,
+		Size:         dbMedia.Size,
+		Width:        dbMedia.Width,
+		Height:       dbMedia.Height,
+		URL:          "/api/media/" + dbMedia.ID,
+		ThumbnailURL: "/api/media/" + dbMedia.ID + "/thumbnail",
+	}
+}
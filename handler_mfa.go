@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+const (
+	mfaChallengeExpiry = 5 * time.Minute
+	recoveryCodeCount  = 10
+)
+
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, user db.User) {
+	tokenId, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge")
+		return
+	}
+
+	token, err := auth.MakePurposeJWT(user.ID, tokenId, auth.PurposeMFAChallenge, cfg.SecretString, mfaChallengeExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge")
+		return
+	}
+	err = cfg.DB.SetMFAChallenge(user.ID, tokenId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+	})
+}
+
+// loginMFA exchanges an MFA challenge token and a TOTP or recovery code for
+// real tokens.
+func (cfg *apiConfig) loginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		Expire       int    `json:"expires_in_seconds"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	claims, err := auth.ValidatePurposeJWT(params.MFAToken, auth.PurposeMFAChallenge, cfg.SecretString)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userId)
+	if err != nil || !user.TOTPEnabled || user.MFAChallengeID != claims.ID {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	throttleKeys := []string{accountThrottleKey(user.Email), ipThrottleKey(r)}
+	wait, err := cfg.loginLockedFor(throttleKeys...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts")
+		return
+	}
+	if wait > 0 {
+		respondWithLockout(w, wait)
+		return
+	}
+
+	counter := int64(0)
+	recoveryCodeHash := ""
+	if params.RecoveryCode != "" {
+		recoveryCodeHash = auth.HashToken(strings.ToLower(strings.TrimSpace(params.RecoveryCode)))
+	} else {
+		var ok bool
+		counter, ok = auth.ValidateTOTP(user.TOTPSecret, params.Code, time.Now())
+		if !ok {
+			cfg.recordLoginFailure(throttleKeys...)
+			respondWithError(w, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+	}
+
+	err = cfg.DB.CompleteMFAChallenge(user.ID, claims.ID, counter, recoveryCodeHash)
+	if errors.Is(err, db.ErrMFAChallengeUsed) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+	if errors.Is(err, db.ErrRecoveryCodeInvalid) {
+		cfg.recordLoginFailure(throttleKeys...)
+		respondWithError(w, http.StatusUnauthorized, "Invalid recovery code")
+		return
+	}
+	if errors.Is(err, db.ErrTOTPCodeReused) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't complete login")
+		return
+	}
+
+	cfg.respondWithLogin(w, user, params.Expire)
+}
+
+func (cfg *apiConfig) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+	if user.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret")
+		return
+	}
+
+	err = cfg.DB.SetPendingTOTP(user.ID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(secret, user.Email, "Chirpy"),
+	})
+}
+
+func (cfg *apiConfig) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+	if user.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if user.TOTPSecret == "" {
+		respondWithError(w, http.StatusBadRequest, "Start enrollment before confirming a code")
+		return
+	}
+
+	counter, ok := auth.ValidateTOTP(user.TOTPSecret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes")
+		return
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashToken(code))
+	}
+
+	err = cfg.DB.EnableTOTP(user.ID, counter, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication")
+		return
+	}
+
+	// Recovery codes are only ever shown here
+	respondWithJSON(w, http.StatusOK, RecoveryCodes{
+		RecoveryCodes: codes,
+	})
+}
+
+func (cfg *apiConfig) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+	if !user.TOTPEnabled {
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+
+	counter, ok := auth.ValidateTOTP(user.TOTPSecret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	err = cfg.DB.UseTOTPCounter(user.ID, counter)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	err = cfg.DB.DisableTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
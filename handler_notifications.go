@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+const (
+	defaultNotificationPageSize = 20
+	maxNotificationPageSize     = 100
+)
+
+type Notification struct {
+	ID        int        `json:"id"`
+	Type      string     `json:"type"`
+	ActorID   int        `json:"actor_id"`
+	ChirpID   int        `json:"chirp_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+type NotificationPrefs struct {
+	Replies  bool `json:"replies"`
+	Mentions bool `json:"mentions"`
+	Follows  bool `json:"follows"`
+}
+
+// getNotifications pages through notifications newest first. Pass the
+// next_before value from a response as ?before= to get the following page.
+func (cfg *apiConfig) getNotifications(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Notifications []Notification `json:"notifications"`
+		UnreadCount   int            `json:"unread_count"`
+		NextBefore    int            `json:"next_before,omitempty"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	limit := defaultNotificationPageSize
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxNotificationPageSize {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+	}
+	before := 0
+	if beforeParam := r.URL.Query().Get("before"); beforeParam != "" {
+		before, err = strconv.Atoi(beforeParam)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid before cursor")
+			return
+		}
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	dbNotifications, err := cfg.DB.GetNotifications(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve notifications")
+		return
+	}
+
+	resp := response{
+		Notifications: []Notification{},
+	}
+	for _, notification := range dbNotifications {
+		unread := notification.ReadAt.IsZero()
+		if unread {
+			resp.UnreadCount++
+		}
+		if before != 0 && notification.ID >= before {
+			continue
+		}
+		if unreadOnly && !unread {
+			continue
+		}
+		if len(resp.Notifications) == limit {
+			resp.NextBefore = resp.Notifications[limit-1].ID
+			continue
+		}
+		resp.Notifications = append(resp.Notifications, notificationResponse(notification))
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// markNotificationsRead marks the listed notifications as read, or every
+// notification when all is set.
+func (cfg *apiConfig) markNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		IDs []int `json:"ids"`
+		All bool  `json:"all"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if !params.All && len(params.IDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Provide ids or set all")
+		return
+	}
+
+	ids := params.IDs
+	if params.All {
+		ids = nil
+	}
+	cfg.respondWithMarkedRead(w, userId, ids)
+}
+
+func (cfg *apiConfig) markNotificationRead(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid notification id")
+		return
+	}
+
+	cfg.respondWithMarkedRead(w, userId, []int{id})
+}
+
+func (cfg *apiConfig) respondWithMarkedRead(w http.ResponseWriter, userId int, ids []int) {
+	type response struct {
+		Marked      int `json:"marked"`
+		UnreadCount int `json:"unread_count"`
+	}
+
+	marked, err := cfg.DB.MarkNotificationsRead(userId, ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark notifications read")
+		return
+	}
+
+	notifications, err := cfg.DB.GetNotifications(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve notifications")
+		return
+	}
+	unread := 0
+	for _, notification := range notifications {
+		if notification.ReadAt.IsZero() {
+			unread++
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Marked:      marked,
+		UnreadCount: unread,
+	})
+}
+
+func (cfg *apiConfig) getNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	prefs, err := cfg.DB.GetNotificationPrefs(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve preferences")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, NotificationPrefs(prefs))
+}
+
+// updateNotificationPrefs changes only the preferences present in the body.
+func (cfg *apiConfig) updateNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Replies  *bool `json:"replies"`
+		Mentions *bool `json:"mentions"`
+		Follows  *bool `json:"follows"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	prefs, err := cfg.DB.GetNotificationPrefs(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve preferences")
+		return
+	}
+	if params.Replies != nil {
+		prefs.Replies = *params.Replies
+	}
+	if params.Mentions != nil {
+		prefs.Mentions = *params.Mentions
+	}
+	if params.Follows != nil {
+		prefs.Follows = *params.Follows
+	}
+
+	err = cfg.DB.SetNotificationPrefs(userId, prefs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update preferences")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, NotificationPrefs(prefs))
+}
+
+func notificationResponse(notification db.Notification) Notification {
+	response := Notification{
+		ID:        notification.ID,
+		Type:      notification.Type,
+		ActorID:   notification.ActorID,
+		ChirpID:   notification.ChirpID,
+		CreatedAt: notification.CreatedAt,
+	}
+	if !notification.ReadAt.IsZero() {
+		readAt := notification.ReadAt
+		response.ReadAt = &readAt
+	}
+	return response
+}
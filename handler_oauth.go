@@ -0,0 +1,559 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+const (
+	scopeChirpsRead  = "chirps:read"
+	scopeChirpsWrite = "chirps:write"
+	scopeProfileRead = "profile:read"
+
+	authorizationCodeExpiry = 10 * time.Minute
+	oauthAccessTokenExpiry  = time.Hour
+)
+
+var errInvalidClientSecret = errors.New("invalid client secret")
+
+var supportedScopes = map[string]bool{
+	scopeChirpsRead:  true,
+	scopeChirpsWrite: true,
+	scopeProfileRead: true,
+}
+
+type OAuthClient struct {
+	ID           string   `json:"client_id"`
+	Secret       string   `json:"client_secret,omitempty"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"`
+}
+
+type OAuthConsent struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	GrantedAt  time.Time `json:"granted_at"`
+}
+
+type OAuthToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+type OAuthIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// respondWithOAuthError uses the error format from RFC 6749 section 5.2 which
+// OAuth client libraries expect, rather than our usual error body.
+func respondWithOAuthError(w http.ResponseWriter, code int, errCode string, description string) {
+	type oauthErrorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, oauthErrorResponse{
+		Error:            errCode,
+		ErrorDescription: description,
+	})
+}
+
+func (cfg *apiConfig) createOAuthClient(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if strings.TrimSpace(params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "Client name is required")
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one redirect URI is required")
+		return
+	}
+	for _, redirectURI := range params.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			respondWithError(w, http.StatusBadRequest, "Redirect URIs must be absolute https URLs, or http on localhost")
+			return
+		}
+	}
+
+	clientId, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create client")
+		return
+	}
+	clientId = clientId[:32]
+
+	client := db.OAuthClient{
+		ID:           clientId,
+		Name:         params.Name,
+		OwnerID:      userId,
+		RedirectURIs: params.RedirectURIs,
+		Confidential: params.Confidential,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	secret := ""
+	if params.Confidential {
+		secret, err = auth.MakeTokenID()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create client")
+			return
+		}
+		client.SecretHash = auth.HashToken(secret)
+	}
+
+	err = cfg.DB.CreateOAuthClient(client)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create client")
+		return
+	}
+
+	// The secret is only ever returned here
+	respondWithJSON(w, http.StatusCreated, OAuthClient{
+		ID:           client.ID,
+		Secret:       secret,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		Confidential: client.Confidential,
+	})
+}
+
+func (cfg *apiConfig) getOAuthClients(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	dbClients, err := cfg.DB.GetOAuthClientsByOwner(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve clients")
+		return
+	}
+
+	clients := []OAuthClient{}
+	for _, client := range dbClients {
+		clients = append(clients, OAuthClient{
+			ID:           client.ID,
+			Name:         client.Name,
+			RedirectURIs: client.RedirectURIs,
+			Confidential: client.Confidential,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+// authorize is called by the Chirpy frontend on behalf of a logged in user.
+// It either asks for consent or returns the client redirect carrying the
+// authorization code.
+func (cfg *apiConfig) authorize(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ResponseType        string `json:"response_type"`
+		ClientID            string `json:"client_id"`
+		RedirectURI         string `json:"redirect_uri"`
+		Scope               string `json:"scope"`
+		State               string `json:"state"`
+		CodeChallenge       string `json:"code_challenge"`
+		CodeChallengeMethod string `json:"code_challenge_method"`
+		Approve             *bool  `json:"approve"`
+	}
+	type consentRequired struct {
+		ConsentRequired bool     `json:"consent_required"`
+		ClientName      string   `json:"client_name"`
+		Scopes          []string `json:"scopes"`
+	}
+	type authorizeResponse struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	// Until the client and redirect URI are known to be good, errors go back to
+	// the user rather than to the redirect URI (RFC 6749 section 4.1.2.1)
+	client, err := cfg.DB.GetOAuthClient(params.ClientID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown client")
+		return
+	}
+	if !containsString(client.RedirectURIs, params.RedirectURI) {
+		respondWithError(w, http.StatusBadRequest, "Redirect URI is not registered for this client")
+		return
+	}
+
+	redirectError := func(errCode string, description string) {
+		query := url.Values{}
+		query.Set("error", errCode)
+		query.Set("error_description", description)
+		if params.State != "" {
+			query.Set("state", params.State)
+		}
+		respondWithJSON(w, http.StatusOK, authorizeResponse{
+			RedirectTo: appendQuery(params.RedirectURI, query),
+		})
+	}
+
+	if params.ResponseType != "code" {
+		redirectError("unsupported_response_type", "Only the authorization code flow is supported")
+		return
+	}
+	if params.CodeChallenge == "" || params.CodeChallengeMethod != "S256" {
+		redirectError("invalid_request", "PKCE with code_challenge_method S256 is required")
+		return
+	}
+
+	scopes := strings.Fields(params.Scope)
+	if len(scopes) == 0 {
+		redirectError("invalid_scope", "At least one scope is required")
+		return
+	}
+	for _, scope := range scopes {
+		if !supportedScopes[scope] {
+			redirectError("invalid_scope", "Unknown scope "+scope)
+			return
+		}
+	}
+
+	consent, consented, err := cfg.DB.GetOAuthConsent(userId, client.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check consent")
+		return
+	}
+	consented = consented && containsAll(consent.Scopes, scopes)
+
+	if !consented && params.Approve == nil {
+		respondWithJSON(w, http.StatusOK, consentRequired{
+			ConsentRequired: true,
+			ClientName:      client.Name,
+			Scopes:          scopes,
+		})
+		return
+	}
+	if params.Approve != nil && !*params.Approve {
+		redirectError("access_denied", "The user denied the request")
+		return
+	}
+
+	if !consented {
+		err = cfg.DB.SaveOAuthConsent(db.OAuthConsent{
+			UserID:    userId,
+			ClientID:  client.ID,
+			Scopes:    mergeScopes(consent.Scopes, scopes),
+			GrantedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record consent")
+			return
+		}
+	}
+
+	code, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create authorization code")
+		return
+	}
+
+	err = cfg.DB.CreateAuthorizationCode(db.AuthorizationCode{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      client.ID,
+		UserID:        userId,
+		RedirectURI:   params.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: params.CodeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(authorizationCodeExpiry),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create authorization code")
+		return
+	}
+
+	query := url.Values{}
+	query.Set("code", code)
+	if params.State != "" {
+		query.Set("state", params.State)
+	}
+	respondWithJSON(w, http.StatusOK, authorizeResponse{
+		RedirectTo: appendQuery(params.RedirectURI, query),
+	})
+}
+
+// exchangeToken implements the authorization_code grant of RFC 6749.
+func (cfg *apiConfig) exchangeToken(w http.ResponseWriter, r *http.Request) {
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Only authorization_code is supported")
+		return
+	}
+
+	code, err := cfg.DB.ConsumeAuthorizationCode(auth.HashToken(r.PostForm.Get("code")))
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code is invalid or expired")
+		return
+	}
+	if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was not issued to this client")
+		return
+	}
+	if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Code verifier does not match")
+		return
+	}
+
+	token, err := cfg.issueScopedAccessToken(code.UserID, client.ID, code.Scopes, oauthAccessTokenExpiry)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't create access token")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, OAuthToken{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oauthAccessTokenExpiry.Seconds()),
+		Scope:       strings.Join(code.Scopes, " "),
+	})
+}
+
+// introspectToken implements RFC 7662. Clients may only introspect tokens
+// that were issued to them.
+func (cfg *apiConfig) introspectToken(w http.ResponseWriter, r *http.Request) {
+	client, err := cfg.authenticateClient(r)
+	if err != nil || !client.Confidential {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Confidential client authentication required")
+		return
+	}
+
+	token, active := cfg.lookupClientToken(client, r.PostForm.Get("token"))
+	if !active {
+		respondWithJSON(w, http.StatusOK, OAuthIntrospection{Active: false})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, OAuthIntrospection{
+		Active:    true,
+		Scope:     strings.Join(token.Scopes, " "),
+		ClientID:  token.ClientID,
+		Subject:   strconv.Itoa(token.UserID),
+		TokenType: "Bearer",
+		ExpiresAt: token.ExpiresAt.Unix(),
+		IssuedAt:  token.IssuedAt.Unix(),
+	})
+}
+
+// revokeOAuthToken implements RFC 7009. Unknown tokens are not an error.
+func (cfg *apiConfig) revokeOAuthToken(w http.ResponseWriter, r *http.Request) {
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	token, active := cfg.lookupClientToken(client, r.PostForm.Get("token"))
+	if active {
+		err = cfg.DB.RevokeAccessToken(token.UserID, token.ID, token.ExpiresAt)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusServiceUnavailable, "server_error", "Couldn't revoke token")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) getOAuthConsents(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	dbConsents, err := cfg.DB.GetOAuthConsentsByUser(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve consents")
+		return
+	}
+
+	consents := []OAuthConsent{}
+	for _, consent := range dbConsents {
+		clientName := ""
+		client, err := cfg.DB.GetOAuthClient(consent.ClientID)
+		if err == nil {
+			clientName = client.Name
+		}
+		consents = append(consents, OAuthConsent{
+			ClientID:   consent.ClientID,
+			ClientName: clientName,
+			Scopes:     consent.Scopes,
+			GrantedAt:  consent.GrantedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, consents)
+}
+
+func (cfg *apiConfig) deleteOAuthConsent(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	err = cfg.DB.DeleteOAuthConsent(userId, r.PathValue("client_id"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find consent for that client")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// authenticateClient reads client credentials from HTTP Basic auth or the
+// form body. Public clients only identify themselves.
+func (cfg *apiConfig) authenticateClient(r *http.Request) (db.OAuthClient, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return db.OAuthClient{}, err
+	}
+
+	clientId, secret, hasBasic := r.BasicAuth()
+	if !hasBasic {
+		clientId = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := cfg.DB.GetOAuthClient(clientId)
+	if err != nil {
+		return db.OAuthClient{}, err
+	}
+
+	if client.Confidential {
+		if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+			return db.OAuthClient{}, errInvalidClientSecret
+		}
+	}
+
+	return client, nil
+}
+
+// lookupClientToken returns the issue record for a live token belonging to
+// the client.
+func (cfg *apiConfig) lookupClientToken(client db.OAuthClient, tokenString string) (db.AccessToken, bool) {
+	claims, err := auth.ParseJWT(tokenString, cfg.SecretString)
+	if err != nil {
+		return db.AccessToken{}, false
+	}
+
+	token, exists, err := cfg.DB.GetAccessToken(claims.ID)
+	if err != nil || !exists || token.ClientID != client.ID {
+		return db.AccessToken{}, false
+	}
+
+	return token, true
+}
+
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+	if u.Scheme == "https" {
+		return true
+	}
+	host := u.Hostname()
+	return u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1" || host == "::1")
+}
+
+func appendQuery(rawURL string, query url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	existing := u.Query()
+	for key, values := range query {
+		existing[key] = values
+	}
+	u.RawQuery = existing.Encode()
+	return u.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(list []string, wanted []string) bool {
+	for _, s := range wanted {
+		if !containsString(list, s) {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeScopes(existing []string, added []string) []string {
+	merged := append([]string{}, existing...)
+	for _, scope := range added {
+		if !containsString(merged, scope) {
+			merged = append(merged, scope)
+		}
+	}
+	return merged
+}
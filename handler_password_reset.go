@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/mail"
+)
+
+const passwordResetExpiry = 30 * time.Minute
+
+func (cfg *apiConfig) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	// Respond the same way whether or not the account exists so this endpoint
+	// can't be used to discover registered emails. The token is created and
+	// mailed in the background so response time doesn't give it away either.
+	user, err := cfg.DB.GetUser(params.Email)
+	if err == nil && user.ID != 0 {
+		go cfg.sendPasswordReset(user)
+	}
+
+	respondWithJSON(w, http.StatusAccepted, "")
+}
+
+// sendPasswordReset replaces the user's reset token and mails the new one.
+func (cfg *apiConfig) sendPasswordReset(user db.User) {
+	token, err := auth.MakeTokenID()
+	if err != nil {
+		log.Printf("Could not create reset token for user %d: %s", user.ID, err)
+		return
+	}
+
+	err = cfg.DB.CreatePasswordReset(user.ID, auth.HashToken(token), time.Now().UTC().Add(passwordResetExpiry))
+	if err != nil {
+		log.Printf("Could not store reset token for user %d: %s", user.ID, err)
+		return
+	}
+
+	err = cfg.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body:    fmt.Sprintf("Send this token with your new password to POST /api/password/reset within 30 minutes:\n\n%s\n\nIf you didn't ask to reset your password you can ignore this email.", token),
+	})
+	if err != nil {
+		log.Printf("Could not send password reset email to user %d: %s", user.ID, err)
+	}
+}
+
+func (cfg *apiConfig) resetPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if params.Token == "" || params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Token and password are required")
+		return
+	}
+
+	err = cfg.PasswordPolicy.Validate(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashedPassword, err := cfg.Hasher.Hash(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		return
+	}
+
+	userId, err := cfg.DB.ResetPassword(auth.HashToken(params.Token), hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired reset token")
+		return
+	}
+
+	// Anyone holding a session for the old password is logged out
+	err = cfg.DB.RevokeAllUserTokens(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke existing sessions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
@@ -2,48 +2,147 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/webhooks"
+)
+
+const (
+	defaultPlan               = "chirpy_red"
+	defaultSubscriptionPeriod = 30 * 24 * time.Hour
+
+	polkaSignatureTolerance = 5 * time.Minute
+	polkaEventRetention     = 7 * 24 * time.Hour
+	maxWebhookBodyBytes     = 1 << 20
 )
 
 func (cfg *apiConfig) upgradeUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
+		ID    string `json:"id"`
 		Event string `json:"event"`
 		Data  struct {
-			ID int `json:"user_id"`
+			ID               int       `json:"user_id"`
+			Plan             string    `json:"plan"`
+			CurrentPeriodEnd time.Time `json:"current_period_end"`
 		} `json:"data"`
 	}
 
-	params := parameters{}
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&params)
+	// The signature covers the exact bytes Polka sent, so read the raw body
+	// before decoding it
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Could not read request")
+		return
+	}
 
+	err = auth.VerifyWebhook(
+		cfg.PolkaKey,
+		r.Header.Get("Polka-Timestamp"),
+		r.Header.Get("Polka-Signature"),
+		body,
+		time.Now(),
+		polkaSignatureTolerance,
+	)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Could not identify request")
+		respondWithError(w, http.StatusUnauthorized, "Invalid webhook signature")
 		return
 	}
 
-	polka, _ := auth.GetPolkaKey(r.Header)
-	if polka != cfg.PolkaKey {
-		fmt.Println(polka)
-		respondWithJSON(w, http.StatusUnauthorized, "User cannot upgrade")
+	params := parameters{}
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Could not identify request")
+		return
+	}
+	if params.ID == "" {
+		respondWithError(w, http.StatusBadRequest, "Webhook event id is required")
 		return
 	}
 
-	if params.Event != "user.upgraded" {
+	if _, handled := subscriptionEvents[params.Event]; !handled {
+		err = cfg.DB.MarkWebhookProcessed(params.ID, params.Event, polkaEventRetention)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not record webhook event")
+			return
+		}
 		respondWithJSON(w, http.StatusNoContent, "")
 		return
 	}
 
-	userId := params.Data.ID
-
-	err = cfg.DB.UpgradeUser(userId)
+	// Only successful events are recorded so failed ones can be retried
+	user, applied, err := cfg.DB.ProcessSubscriptionEvent(params.ID, params.Event, params.Data.ID, polkaEventRetention, func(current db.Subscription, now time.Time) db.Subscription {
+		return nextSubscription(current, params.Event, params.Data.Plan, params.Data.CurrentPeriodEnd, now)
+	})
+	if errors.Is(err, db.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Could not upgrade user")
+		respondWithError(w, http.StatusInternalServerError, "Could not update subscription")
 		return
 	}
 
+	if applied && params.Event == "user.upgraded" {
+		cfg.Webhooks.Publish(webhooks.EventUserUpgraded, user.ID, struct {
+			UserID int    `json:"user_id"`
+			IsRed  bool   `json:"is_chirpy_red"`
+			Plan   string `json:"plan"`
+		}{
+			UserID: user.ID,
+			IsRed:  user.IsRed,
+			Plan:   user.Subscription.Plan,
+		})
+	}
+
 	respondWithJSON(w, http.StatusNoContent, "")
 }
+
+var subscriptionEvents = map[string]struct{}{
+	"user.upgraded":               {},
+	"user.downgraded":             {},
+	"subscription.renewed":        {},
+	"subscription.cancelled":      {},
+	"subscription.payment_failed": {},
+}
+
+// nextSubscription applies a Polka lifecycle event to the current
+// subscription. Cancellation and payment failure keep Chirpy Red until the
+// paid period ends; a downgrade removes it immediately.
+func nextSubscription(current db.Subscription, event string, plan string, periodEnd time.Time, now time.Time) db.Subscription {
+	next := current
+	if plan != "" {
+		next.Plan = plan
+	}
+	if next.Plan == "" {
+		next.Plan = defaultPlan
+	}
+	if !periodEnd.IsZero() {
+		next.CurrentPeriodEnd = periodEnd.UTC()
+	}
+
+	switch event {
+	case "user.upgraded", "subscription.renewed":
+		next.Status = db.SubscriptionActive
+		if periodEnd.IsZero() {
+			start := now
+			if current.CurrentPeriodEnd.After(now) {
+				start = current.CurrentPeriodEnd
+			}
+			next.CurrentPeriodEnd = start.Add(defaultSubscriptionPeriod)
+		}
+	case "subscription.cancelled":
+		next.Status = db.SubscriptionCancelled
+	case "subscription.payment_failed":
+		next.Status = db.SubscriptionPastDue
+	case "user.downgraded":
+		next.Status = db.SubscriptionInactive
+		next.CurrentPeriodEnd = now
+	}
+
+	return next
+}
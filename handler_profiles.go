@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/validation"
+)
+
+// Author is the lightweight view of a user embedded in chirps.
+type Author struct {
+	ID          int    `json:"id"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// Profile is a user's public profile. It never includes the email address.
+type Profile struct {
+	ID             int    `json:"id"`
+	Handle         string `json:"handle"`
+	DisplayName    string `json:"display_name"`
+	Bio            string `json:"bio"`
+	AvatarMediaID  string `json:"avatar_media_id,omitempty"`
+	AvatarURL      string `json:"avatar_url,omitempty"`
+	IsPrivate      bool   `json:"is_private"`
+	IsRed          bool   `json:"is_chirpy_red"`
+	ChirpCount     int    `json:"chirp_count"`
+	FollowerCount  int    `json:"follower_count"`
+	FollowingCount int    `json:"following_count"`
+}
+
+// getProfile looks up a profile by handle, ignoring case. Users who have a
+// block with the caller get a 404 as if the account didn't exist.
+func (cfg *apiConfig) getProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.DB.GetUserByHandle(r.PathValue("handle"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+
+	audience, err := cfg.viewerAudience(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve profile")
+		return
+	}
+	if audience.Blocked[user.ID] {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+
+	stats, err := cfg.DB.GetProfileStats(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve profile")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profileResponse(user, stats))
+}
+
+// updateProfile changes the caller's handle, display name, bio or avatar.
+// Fields left out of the request are unchanged; an empty avatar_media_id
+// removes the avatar.
+func (cfg *apiConfig) updateProfile(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Handle        *string `json:"handle"`
+		DisplayName   *string `json:"display_name"`
+		Bio           *string `json:"bio"`
+		AvatarMediaID *string `json:"avatar_media_id"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userId)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	profile := db.Profile{
+		Handle:        user.Handle,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarMediaID: user.AvatarMediaID,
+	}
+
+	if params.Handle != nil {
+		err = validation.ValidateHandle(*params.Handle)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		profile.Handle = *params.Handle
+	}
+	if params.DisplayName != nil {
+		profile.DisplayName, err = validation.ValidateDisplayName(*params.DisplayName)
+		if err != nil {
+			respondWithTextValidationError(w, "Display name", err)
+			return
+		}
+	}
+	if params.Bio != nil {
+		profile.Bio, err = validation.ValidateBio(*params.Bio)
+		if err != nil {
+			respondWithTextValidationError(w, "Bio", err)
+			return
+		}
+	}
+	if params.AvatarMediaID != nil {
+		if *params.AvatarMediaID != "" {
+			avatar, err := cfg.DB.GetMedia(*params.AvatarMediaID)
+			if err != nil || avatar.OwnerID != userId {
+				respondWithError(w, http.StatusBadRequest, "Avatar must be media you uploaded")
+				return
+			}
+		}
+		profile.AvatarMediaID = *params.AvatarMediaID
+	}
+
+	user, err = cfg.DB.UpdateProfile(userId, profile)
+	if errors.Is(err, db.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, "That handle is already taken")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update profile")
+		return
+	}
+
+	stats, err := cfg.DB.GetProfileStats(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve profile")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profileResponse(user, stats))
+}
+
+func profileResponse(user db.User, stats db.ProfileStats) Profile {
+	return Profile{
+		ID:             user.ID,
+		Handle:         user.Handle,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		AvatarMediaID:  user.AvatarMediaID,
+		AvatarURL:      avatarURL(user),
+		IsPrivate:      user.IsPrivate,
+		IsRed:          user.IsRed,
+		ChirpCount:     stats.Chirps,
+		FollowerCount:  stats.Followers,
+		FollowingCount: stats.Following,
+	}
+}
+
+func authorResponse(user db.User) *Author {
+	return &Author{
+		ID:          user.ID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		AvatarURL:   avatarURL(user),
+	}
+}
+
+// avatarURL points at the thumbnail of the user's avatar, which is small
+// enough to show next to every chirp.
+func avatarURL(user db.User) string {
+	if user.AvatarMediaID == "" {
+		return ""
+	}
+	return "/api/media/" + user.AvatarMediaID + "/thumbnail"
+}
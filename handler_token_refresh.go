@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 	"time"
 
@@ -21,17 +20,15 @@ func (cfg *apiConfig) refreshJWT(w http.ResponseWriter, r *http.Request) {
 
 	id, err := cfg.DB.GetRefreshToken(refreshToken)
 	if err != nil {
-		fmt.Println("this error")
 		respondWithError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 
-	tokenString, err := auth.MakeJWT(id, cfg.SecretString, time.Hour*1)
+	tokenString, err := cfg.issueAccessToken(id, time.Hour*1)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not create new JWT")
 		return
 	}
-	fmt.Println(tokenString)
 	respondWithJSON(w, http.StatusOK, TokenResponse{
 		Token: tokenString,
 	})
@@ -2,13 +2,20 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/mail"
+
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/validation"
 )
 
 func (cfg *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// Handle is optional; users without one get a generated handle
+		Handle string `json:"handle"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -20,25 +27,48 @@ func (cfg *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existingUser, err := cfg.DB.GetUser(params.Email)
+	address, err := mail.ParseAddress(params.Email)
+	if err != nil || address.Address != params.Email {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	if params.Handle != "" {
+		err = validation.ValidateHandle(params.Handle)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	err = cfg.PasswordPolicy.Validate(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if existingUser.ID != 0 {
-		respondWithError(w, 401, "User  with that email already exists")
+
+	hashedPassword, err := cfg.Hasher.Hash(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
 		return
 	}
 
-	user, err := cfg.DB.CreateUser(params.Email, params.Password)
+	user, err := cfg.DB.CreateUser(params.Email, hashedPassword, params.Handle)
+	if errors.Is(err, db.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, "User with that email already exists")
+		return
+	}
+	if errors.Is(err, db.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, "That handle is already taken")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	respondWithJSON(w, 201, User{
-		user.ID,
-		user.Email,
-		"",
-		"",
-		false,
-	})
+
+	// The account exists either way; the user can ask for a new email later
+	cfg.sendVerificationEmail(user)
+
+	respondWithJSON(w, 201, privateUserResponse(user))
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/webhooks"
+)
+
+// deleteUser permanently deletes the caller's account and everything that
+// belongs to it. The password has to be entered again, along with a TOTP
+// code if two-factor authentication is on, so a stolen access token alone
+// can't destroy an account. Streams open as the user are closed.
+func (cfg *apiConfig) deleteUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+
+	// Share the login lockout so this can't be used to guess the password
+	throttleKeys := []string{accountThrottleKey(user.Email), ipThrottleKey(r)}
+	wait, err := cfg.loginLockedFor(throttleKeys...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts")
+		return
+	}
+	if wait > 0 {
+		respondWithLockout(w, wait)
+		return
+	}
+	_, err = cfg.checkPasswordUniform(params.Password, user.Password)
+	if err != nil {
+		cfg.recordLoginFailure(throttleKeys...)
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password")
+		return
+	}
+	if user.TOTPEnabled {
+		counter, ok := auth.ValidateTOTP(user.TOTPSecret, params.Code, time.Now())
+		if !ok {
+			cfg.recordLoginFailure(throttleKeys...)
+			respondWithError(w, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+		err = cfg.DB.UseTOTPCounter(user.ID, counter)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+	}
+
+	deleted, err := cfg.DB.DeleteUser(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account")
+		return
+	}
+	cfg.DB.ClearLoginAttempts(accountThrottleKey(user.Email))
+	cfg.Notifications.PublishUserDeleted(userId)
+
+	err = cfg.DB.DeleteBlobs(deleted.BlobKeys, cfg.Blobs.Delete)
+	if err != nil {
+		log.Printf("Couldn't delete blobs of deleted user %d: %s", userId, err)
+	}
+	for _, chirp := range deleted.Chirps {
+		if chirp.Published() {
+			cfg.Webhooks.Publish(webhooks.EventChirpDeleted, userId, Chirp{
+				ID:       chirp.ID,
+				AuthorID: chirp.AuthorID,
+			})
+		}
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
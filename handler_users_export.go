@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// exportUser sends the caller a ZIP archive of everything stored about them:
+// data.json holds their records and media/ holds the files they uploaded.
+// Unlike other responses the records are written as stored, minus
+// credentials, since the point of an export is to show exactly what we keep.
+func (cfg *apiConfig) exportUser(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	export, err := cfg.DB.ExportUser(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't export account data")
+		return
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't export account data")
+		return
+	}
+
+	filename := fmt.Sprintf("chirpy-export-%d-%s.zip", userId, time.Now().UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are already sent, so failures from here on can only be logged
+	archive := zip.NewWriter(w)
+	file, err := archive.Create("data.json")
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	for _, media := range export.Media {
+		if err != nil {
+			break
+		}
+		err = cfg.exportBlob(archive, "media/"+media.ID+mediaExtension(media.ContentType), media.BlobKey)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		log.Printf("Couldn't write export for user %d: %s", userId, err)
+	}
+}
+
+func (cfg *apiConfig) exportBlob(archive *zip.Writer, name string, key string) error {
+	blob, err := cfg.Blobs.Open(key)
+	if err != nil {
+		// A missing blob shouldn't keep the user from getting the rest
+		log.Printf("Couldn't open blob %s for export: %s", key, err)
+		return nil
+	}
+	defer blob.Close()
+
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, blob)
+	return err
+}
+
+func mediaExtension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	}
+	return ""
+}
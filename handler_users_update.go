@@ -2,38 +2,21 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"strconv"
+	"net/mail"
 
-	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
 )
 
-type User struct {
-	ID           int    `json:"id"`
-	Email        string `json:"email"`
-	Token        string `json:"token"`
-	RefreshToken string `json:"refresh_token"`
-	IsRed        bool   `json:"is_chirpy_red"`
-}
-
-type UserLogin struct {
-	ID     int    `json:"id"`
-	Email  string `json:"email"`
-	Expire int    `json:"expires_in_seconds,omitempty"`
-}
-
 func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
-		return
-	}
-	subject, err := auth.ValidateJWT(token, cfg.SecretString)
+	numId, _, err := cfg.authenticate(r)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
@@ -47,24 +30,46 @@ func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	address, err := mail.ParseAddress(params.Email)
+	if err != nil || address.Address != params.Email {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	err = cfg.PasswordPolicy.Validate(params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	numId, err := strconv.Atoi(subject)
+	hashedPassword, err := cfg.Hasher.Hash(params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
 		return
 	}
 
 	err = cfg.DB.UpdateUser(numId, params.Email, hashedPassword)
+	if errors.Is(err, db.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, "User with that email already exists")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user")
 		return
 	}
-	respondWithJSON(w, http.StatusOK, User{
-		ID:    numId,
-		Email: params.Email,
-	})
+
+	user, err := cfg.DB.GetUserByID(numId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+		return
+	}
+	// Changing the email address puts the account back into the unverified state
+	if !user.IsVerified && user.VerificationTokenID == "" {
+		err = cfg.sendVerificationEmail(user)
+		if err != nil {
+			log.Printf("Could not start verification for user %d: %s", user.ID, err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, privateUserResponse(user))
 }
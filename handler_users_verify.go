@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/mail"
+)
+
+const verificationTokenExpiry = 24 * time.Hour
+
+func (cfg *apiConfig) verifyUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	claims, err := auth.ValidatePurposeJWT(params.Token, auth.PurposeVerifyEmail, cfg.SecretString)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid verification token")
+		return
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid verification token")
+		return
+	}
+
+	user, err := cfg.DB.VerifyUser(userId, claims.ID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid verification token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, privateUserResponse(user))
+}
+
+func (cfg *apiConfig) resendVerification(w http.ResponseWriter, r *http.Request) {
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+	if user.IsVerified {
+		respondWithError(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+
+	err = cfg.sendVerificationEmail(user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not send verification email")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// sendVerificationEmail issues a new single-use token, which invalidates any
+// token sent previously, and mails it to the user.
+func (cfg *apiConfig) sendVerificationEmail(user db.User) error {
+	tokenId, err := auth.MakeTokenID()
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.MakePurposeJWT(user.ID, tokenId, auth.PurposeVerifyEmail, cfg.SecretString, verificationTokenExpiry)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.DB.SetVerificationToken(user.ID, tokenId)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy account",
+		Body:    fmt.Sprintf("Confirm your email address by sending this token to POST /api/users/verify within 24 hours:\n\n%s", token),
+	})
+	if err != nil {
+		log.Printf("Could not send verification email to user %d: %s", user.ID, err)
+		return err
+	}
+
+	return nil
+}
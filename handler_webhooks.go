@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/webhooks"
+)
+
+type WebhookEndpoint struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID             string     `json:"id"`
+	Event          string     `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// webhookOwner resolves who is managing webhooks. The admin key manages the
+// admin endpoints, which have owner 0 and receive events for every user.
+func (cfg *apiConfig) webhookOwner(r *http.Request) (int, bool) {
+	if cfg.isAdmin(r) {
+		return 0, true
+	}
+
+	userId, _, err := cfg.authenticate(r)
+	if err != nil {
+		return 0, false
+	}
+	return userId, true
+}
+
+func (cfg *apiConfig) createWebhook(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	ownerId, ok := cfg.webhookOwner(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	err = cfg.Webhooks.CheckURL(r.Context(), params.URL)
+	if errors.Is(err, webhooks.ErrInvalidURL) {
+		respondWithError(w, http.StatusBadRequest, "Webhook URL must be an absolute http or https URL")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Webhook URL must resolve to a public address")
+		return
+	}
+	if len(params.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one event is required")
+		return
+	}
+	for _, event := range params.Events {
+		if !webhooks.Events[event] {
+			respondWithError(w, http.StatusBadRequest, "Unknown event "+event)
+			return
+		}
+	}
+
+	id, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook")
+		return
+	}
+	secret, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook")
+		return
+	}
+
+	endpoint := db.WebhookEndpoint{
+		ID:        id[:16],
+		OwnerID:   ownerId,
+		URL:       params.URL,
+		Events:    params.Events,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}
+	err = cfg.DB.CreateWebhookEndpoint(endpoint)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook")
+		return
+	}
+
+	// The signing secret is only returned when the endpoint is created
+	response := webhookEndpointResponse(endpoint)
+	response.Secret = endpoint.Secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	ownerId, ok := cfg.webhookOwner(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	dbEndpoints, err := cfg.DB.GetWebhookEndpointsByOwner(ownerId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks")
+		return
+	}
+
+	endpoints := []WebhookEndpoint{}
+	for _, endpoint := range dbEndpoints {
+		endpoints = append(endpoints, webhookEndpointResponse(endpoint))
+	}
+
+	respondWithJSON(w, http.StatusOK, endpoints)
+}
+
+func (cfg *apiConfig) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ownerId, ok := cfg.webhookOwner(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	err := cfg.DB.DeleteWebhookEndpoint(ownerId, r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find webhook")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	ownerId, ok := cfg.webhookOwner(r)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	endpoint, err := cfg.DB.GetWebhookEndpoint(r.PathValue("id"))
+	if err != nil || endpoint.OwnerID != ownerId {
+		respondWithError(w, http.StatusNotFound, "Could not find webhook")
+		return
+	}
+
+	dbDeliveries, err := cfg.DB.GetWebhookDeliveries(endpoint.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve deliveries")
+		return
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range dbDeliveries {
+		response := WebhookDelivery{
+			ID:             delivery.ID,
+			Event:          delivery.Event,
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			LastStatusCode: delivery.LastStatusCode,
+			LastError:      delivery.LastError,
+			CreatedAt:      delivery.CreatedAt,
+		}
+		if !delivery.LastAttemptAt.IsZero() {
+			lastAttempt := delivery.LastAttemptAt
+			response.LastAttemptAt = &lastAttempt
+		}
+		if delivery.Status == db.DeliveryPending {
+			nextAttempt := delivery.NextAttemptAt
+			response.NextAttemptAt = &nextAttempt
+		}
+		deliveries = append(deliveries, response)
+	}
+
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+func webhookEndpointResponse(endpoint db.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:        endpoint.ID,
+		URL:       endpoint.URL,
+		Events:    endpoint.Events,
+		CreatedAt: endpoint.CreatedAt,
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/pubsub"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingInterval   = 30 * time.Second
+	wsMaxMessageSize = 4096
+	wsOutboxSize     = 16
+
+	// Application close codes live in the 4000-4999 range
+	wsCloseTokenExpired   = 4001 // also sent once the token is revoked
+	wsCloseSlowConsumer   = 4008
+	wsCloseAccountDeleted = 4010
+
+	// wsProtocol is the subprotocol the server agrees to. Browser clients
+	// offer it alongside "bearer.<jwt>", since the token can't go in a header
+	wsProtocol       = "chirpy"
+	wsBearerProtocol = "bearer."
+
+	wsTopicNotifications = "notifications"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{wsProtocol},
+}
+
+type wsClientMessage struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic"`
+	Token string `json:"token"`
+}
+
+type wsServerMessage struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	Topic   string      `json:"topic,omitempty"`
+	Event   string      `json:"event,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+// wsConn holds the per-connection state shared by the read loop, the write
+// loop and the broker filter.
+type wsConn struct {
+	conn     *websocket.Conn
+	userId   int
+	mu       *sync.Mutex
+	topics   map[string]bool
+	outbox   chan wsServerMessage
+	audience *liveAudience
+	reauth   chan auth.TokenClaims
+	done     chan struct{}
+	once     *sync.Once
+}
+
+// serveWebSocket upgrades to a WebSocket that streams events for the topics
+// the client subscribes to: "timeline" for every chirp, "user:<id>" for a
+// single author and "notifications" for the caller's own notifications.
+// Browsers can't set headers on WebSocket requests, so the JWT may instead be
+// offered as a "bearer.<jwt>" subprotocol next to "chirpy". It is never taken
+// from the query string, which ends up in access logs.
+func (cfg *apiConfig) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		for _, protocol := range websocket.Subprotocols(r) {
+			if token, found := strings.CutPrefix(protocol, wsBearerProtocol); found {
+				r.Header.Set("Authorization", "Bearer "+token)
+				break
+			}
+		}
+	}
+
+	userId, claims, err := cfg.authenticateScoped(r, scopeChirpsRead)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	audience, err := cfg.newLiveAudience(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open connection")
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &wsConn{
+		conn:     conn,
+		userId:   userId,
+		mu:       &sync.Mutex{},
+		topics:   map[string]bool{},
+		outbox:   make(chan wsServerMessage, wsOutboxSize),
+		audience: audience,
+		reauth:   make(chan auth.TokenClaims, 1),
+		done:     make(chan struct{}),
+		once:     &sync.Once{},
+	}
+
+	sub, _, _ := cfg.Broker.Subscribe("", c.wants)
+	defer cfg.Broker.Unsubscribe(sub)
+	notificationSub, _, _ := cfg.Notifications.Subscribe("", c.wantsNotification)
+	defer cfg.Notifications.Unsubscribe(notificationSub)
+
+	go c.writeLoop(cfg, sub, notificationSub, claims)
+	c.readLoop(cfg)
+}
+
+func (c *wsConn) wants(event pubsub.Event) bool {
+	return c.topicFor(event) != "" && c.audience.CanView(event.Chirp)
+}
+
+func (c *wsConn) wantsNotification(event pubsub.Event) bool {
+	if event.Type == pubsub.EventUserDeleted {
+		return event.UserID == c.userId
+	}
+	return event.Notification.UserID == c.userId && c.subscribed(wsTopicNotifications)
+}
+
+func (c *wsConn) subscribed(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.topics[topic]
+}
+
+// topicFor returns the subscribed topic an event is delivered under,
+// preferring the more specific author topic. Muted authors only come
+// through their own topic, never the timeline.
+func (c *wsConn) topicFor(event pubsub.Event) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	userTopic := "user:" + strconv.Itoa(event.Chirp.AuthorID)
+	if c.topics[userTopic] {
+		return userTopic
+	}
+	if c.topics["timeline"] && !c.audience.Hides(event.Chirp) {
+		return "timeline"
+	}
+	return ""
+}
+
+func (c *wsConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+// send queues a reply without blocking. A client that lets its outbox fill up
+// is disconnected rather than allowed to hold memory.
+func (c *wsConn) send(msg wsServerMessage) {
+	select {
+	case c.outbox <- msg:
+	default:
+		c.close()
+	}
+}
+
+func (c *wsConn) readLoop(cfg *apiConfig) {
+	defer c.close()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		msg := wsClientMessage{}
+		err := c.conn.ReadJSON(&msg)
+		if err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case "subscribe", "unsubscribe":
+			if !validTopic(msg.Topic) {
+				c.send(wsServerMessage{Type: "error", Message: "Unknown topic " + msg.Topic})
+				continue
+			}
+			c.mu.Lock()
+			if msg.Type == "subscribe" {
+				c.topics[msg.Topic] = true
+			} else {
+				delete(c.topics, msg.Topic)
+			}
+			c.mu.Unlock()
+			c.send(wsServerMessage{Type: msg.Type + "d", Topic: msg.Topic})
+		case "ping":
+			c.send(wsServerMessage{Type: "pong"})
+		case "auth":
+			// Lets a client swap in a fresh token before the current one
+			// expires instead of reconnecting
+			claims, err := auth.ParseJWT(msg.Token, cfg.SecretString)
+			if err != nil || claims.Subject != strconv.Itoa(c.userId) || !claims.HasScope(scopeChirpsRead) {
+				c.send(wsServerMessage{Type: "error", Message: "Invalid token"})
+				continue
+			}
+			revoked, err := cfg.DB.IsAccessTokenRevoked(claims.ID)
+			if err != nil || revoked {
+				c.send(wsServerMessage{Type: "error", Message: "Invalid token"})
+				continue
+			}
+			select {
+			case <-c.reauth:
+			default:
+			}
+			c.reauth <- claims
+			c.send(wsServerMessage{Type: "authenticated"})
+		default:
+			c.send(wsServerMessage{Type: "error", Message: "Unknown message type"})
+		}
+	}
+}
+
+// writeLoop is the only goroutine that writes to the connection, as gorilla
+// requires. claims belong to the token the connection is currently
+// authenticated with; an "auth" message replaces them.
+func (c *wsConn) writeLoop(cfg *apiConfig, sub *pubsub.Subscription, notificationSub *pubsub.Subscription, claims auth.TokenClaims) {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	expired := time.NewTimer(time.Until(claims.ExpiresAt))
+	defer expired.Stop()
+	defer c.conn.Close()
+
+	for {
+		select {
+		case <-c.done:
+			c.closeWith(websocket.CloseNormalClosure, "")
+			return
+		case claims = <-c.reauth:
+			if !expired.Stop() {
+				select {
+				case <-expired.C:
+				default:
+				}
+			}
+			expired.Reset(time.Until(claims.ExpiresAt))
+		case <-expired.C:
+			c.closeWith(wsCloseTokenExpired, "token expired")
+			return
+		case <-ping.C:
+			revoked, err := cfg.credentialRevoked(claims)
+			if err != nil || revoked {
+				c.closeWith(wsCloseTokenExpired, "token revoked")
+				return
+			}
+			c.audience.Refresh()
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case msg := <-c.outbox:
+			if !c.write(msg) {
+				return
+			}
+		case event, open := <-sub.C:
+			if !open {
+				c.closeDropped()
+				return
+			}
+			topic := c.topicFor(event)
+			if topic == "" {
+				// Unsubscribed after the event was queued
+				continue
+			}
+			if !c.write(wsEventMessage(event, topic)) {
+				return
+			}
+		case event, open := <-notificationSub.C:
+			if !open {
+				c.closeDropped()
+				return
+			}
+			if event.Type == pubsub.EventUserDeleted {
+				c.closeWith(wsCloseAccountDeleted, "account deleted")
+				return
+			}
+			if !c.subscribed(wsTopicNotifications) {
+				continue
+			}
+			msg := wsServerMessage{
+				Type:  "event",
+				ID:    event.ID,
+				Topic: wsTopicNotifications,
+				Event: event.Type,
+				Data:  notificationResponse(event.Notification),
+			}
+			if !c.write(msg) {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConn) write(msg wsServerMessage) bool {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(msg) == nil
+}
+
+func (c *wsConn) closeWith(code int, reason string) {
+	c.close()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+}
+
+// closeDropped closes the connection after a broker closed one of its
+// subscriptions. That happens to slow consumers, but also when readLoop
+// returns and serveWebSocket unsubscribes, which is a normal disconnect.
+func (c *wsConn) closeDropped() {
+	select {
+	case <-c.done:
+		c.closeWith(websocket.CloseNormalClosure, "")
+	default:
+		c.closeWith(wsCloseSlowConsumer, "too slow to keep up, reconnect")
+	}
+}
+
+func wsEventMessage(event pubsub.Event, topic string) wsServerMessage {
+	chirp := Chirp{
+		ID:       event.Chirp.ID,
+		AuthorID: event.Chirp.AuthorID,
+	}
+	if event.Type != pubsub.EventChirpDeleted {
+		chirp.Body = event.Chirp.Body
+	}
+
+	return wsServerMessage{
+		Type:  "event",
+		ID:    event.ID,
+		Topic: topic,
+		Event: event.Type,
+		Data:  chirp,
+	}
+}
+
+func validTopic(topic string) bool {
+	if topic == "timeline" || topic == wsTopicNotifications {
+		return true
+	}
+	id, found := strings.CutPrefix(topic, "user:")
+	if !found {
+		return false
+	}
+	_, err := strconv.Atoi(id)
+	return err == nil
+}
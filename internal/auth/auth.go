@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -8,63 +11,170 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var ErrNoAuthHeader = errors.New("no auth header included in request")
+var ErrInvalidIssuer = errors.New("token was not issued by chirpy")
+var ErrWrongPurpose = errors.New("token was issued for a different purpose")
 
-func HashPassword(password string) (string, error) {
-	data, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
+const PurposeVerifyEmail = "verify-email"
 
-	return string(data), nil
+type TokenClaims struct {
+	ID        string
+	Subject   string
+	ExpiresAt time.Time
+	// Scopes is empty for first-party tokens, which carry full access
+	Scopes []string
+}
+
+// HasScope reports whether the token grants the scope. First-party tokens
+// grant every scope.
+func (c TokenClaims) HasScope(scope string) bool {
+	if len(c.Scopes) == 0 {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
 }
 
-func CheckPasswordHash(password string, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+type chirpyClaims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope,omitempty"`
 }
 
-func MakeJWT(userID int, tokenSecret string, expiresIn time.Duration) (string, error) {
+// MakeJWT signs an access token. Pass nil scopes for a first-party token;
+// tokens issued to OAuth clients are limited to the scopes they were granted.
+func MakeJWT(userID int, tokenID string, scopes []string, tokenSecret string, expiresIn time.Duration) (string, error) {
 	signingKey := []byte(tokenSecret)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   fmt.Sprintf("%d", userID),
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, chirpyClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   fmt.Sprintf("%d", userID),
+			ID:        tokenID,
+		},
+		Scope: strings.Join(scopes, " "),
 	})
 
 	return token.SignedString(signingKey)
 }
 
 func ValidateJWT(tokenString string, tokenSecret string) (string, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+	claims, err := ParseJWT(tokenString, tokenSecret)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
+// ParseJWT validates the token and returns the claims needed to check it
+// against the revocation list.
+func ParseJWT(tokenString string, tokenSecret string) (TokenClaims, error) {
+	claimsStruct := chirpyClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
 	)
 	if err != nil {
-		return "", err
+		return TokenClaims{}, err
 	}
 
 	userIdString, err := token.Claims.GetSubject()
 	if err != nil {
-		return "", err
+		return TokenClaims{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return "", err
+		return TokenClaims{}, err
 	}
 
 	if issuer != string("chirpy") {
+		return TokenClaims{}, ErrInvalidIssuer
+	}
+
+	// Purpose tokens carry an audience and must never work as access tokens
+	if len(claimsStruct.Audience) > 0 {
+		return TokenClaims{}, ErrWrongPurpose
+	}
+
+	expiresAt, err := token.Claims.GetExpirationTime()
+	if err != nil {
+		return TokenClaims{}, err
+	}
+
+	claims := TokenClaims{
+		ID:      claimsStruct.ID,
+		Subject: userIdString,
+		Scopes:  strings.Fields(claimsStruct.Scope),
+	}
+	if expiresAt != nil {
+		claims.ExpiresAt = expiresAt.Time
+	}
+
+	return claims, nil
+}
+
+// MakePurposeJWT signs a token that is only accepted by ValidatePurposeJWT for
+// the same purpose, such as confirming an email address.
+func MakePurposeJWT(userID int, tokenID string, purpose string, tokenSecret string, expiresIn time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   fmt.Sprintf("%d", userID),
+		Audience:  jwt.ClaimStrings{purpose},
+		ID:        tokenID,
+	})
+
+	return token.SignedString([]byte(tokenSecret))
+}
+
+func ValidatePurposeJWT(tokenString string, purpose string, tokenSecret string) (TokenClaims, error) {
+	claimsStruct := jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claimsStruct,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		jwt.WithIssuer("chirpy"),
+		jwt.WithAudience(purpose),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return TokenClaims{}, err
+	}
+
+	return TokenClaims{
+		ID:        claimsStruct.ID,
+		Subject:   claimsStruct.Subject,
+		ExpiresAt: claimsStruct.ExpiresAt.Time,
+	}, nil
+}
+
+// HashToken returns a SHA-256 digest of an opaque token so it can be stored
+// without keeping the token itself.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MakeTokenID returns a random hex string suitable for JWT IDs and refresh tokens.
+func MakeTokenID() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
 		return "", err
 	}
 
-	return userIdString, nil
+	return hex.EncodeToString(b), nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -80,7 +190,8 @@ func GetBearerToken(headers http.Header) (string, error) {
 	return splitAuth[1], nil
 }
 
-func GetPolkaKey(headers http.Header) (string, error) {
+// GetAPIKey reads a key sent as "Authorization: ApiKey <key>".
+func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
 		return "", ErrNoAuthHeader
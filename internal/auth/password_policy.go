@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrPasswordBreached = errors.New("password appears in a list of breached passwords")
+
+// PasswordPolicy decides which new passwords are acceptable.
+type PasswordPolicy struct {
+	// MinLength counts characters, MaxLength counts bytes
+	MinLength int
+	MaxLength int
+	breached  map[string]struct{}
+}
+
+func NewPasswordPolicy(minLength int, maxLength int) *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength: minLength,
+		MaxLength: maxLength,
+		breached:  map[string]struct{}{},
+	}
+}
+
+// LoadBreachedPasswords reads one password per line. Lines that are 40 hex
+// characters are treated as SHA-1 digests, matching the format of the public
+// breached password corpora.
+func (p *PasswordPolicy) LoadBreachedPasswords(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// Some lists append ":<count>" to each digest
+		if digest, _, found := strings.Cut(line, ":"); found && isSHA1Hex(digest) {
+			line = digest
+		}
+		if isSHA1Hex(line) {
+			p.breached[strings.ToUpper(line)] = struct{}{}
+		} else {
+			p.breached[sha1Hex(line)] = struct{}{}
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (p *PasswordPolicy) Validate(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	// MaxLength is in bytes since bcrypt rejects anything past 72 bytes
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return errors.New("password is too long")
+	}
+	if _, exists := p.breached[sha1Hex(password)]; exists {
+		return ErrPasswordBreached
+	}
+
+	return nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSignature = errors.New("webhook signature is invalid")
+var ErrStaleTimestamp = errors.New("webhook timestamp is outside the tolerance window")
+
+// SignWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>". Signing
+// the timestamp along with the body stops old deliveries being replayed with
+// a fresh timestamp.
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature in constant time and rejects timestamps
+// further than tolerance from now. The signature may carry a "v1=" prefix.
+func VerifyWebhook(secret string, timestamp string, signature string, body []byte, now time.Time, tolerance time.Duration) error {
+	if secret == "" {
+		return ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	sent := time.Unix(seconds, 0)
+	if now.Sub(sent) > tolerance || sent.Sub(now) > tolerance {
+		return ErrStaleTimestamp
+	}
+
+	expected := SignWebhook(secret, timestamp, body)
+	signature = strings.TrimPrefix(strings.TrimSpace(signature), "v1=")
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// AccessToken records a JWT at issue time so it can be revoked later.
+type AccessToken struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"user_id"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RevokedToken is kept until the JWT it refers to would have expired anyway.
+type RevokedToken struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (db *DB) RecordAccessToken(token AccessToken) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		dbStructure.pruneTokens(time.Now().UTC())
+		dbStructure.AccessTokens[token.ID] = token
+		return nil
+	})
+}
+
+// GetAccessToken returns the issue record for an outstanding token. Revoked
+// and expired tokens are not found.
+func (db *DB) GetAccessToken(tokenId string) (AccessToken, bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return AccessToken{}, false, err
+	}
+
+	token, exists := dbStructure.AccessTokens[tokenId]
+	if !exists || !token.ExpiresAt.After(time.Now().UTC()) {
+		return AccessToken{}, false, nil
+	}
+
+	return token, true, nil
+}
+
+func (db *DB) IsAccessTokenRevoked(tokenId string) (bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	revoked, exists := dbStructure.RevokedTokens[tokenId]
+	if !exists {
+		return false, nil
+	}
+
+	return revoked.ExpiresAt.After(time.Now().UTC()), nil
+}
+
+func (db *DB) RevokeAccessToken(userId int, tokenId string, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		dbStructure.pruneTokens(time.Now().UTC())
+		delete(dbStructure.AccessTokens, tokenId)
+		dbStructure.RevokedTokens[tokenId] = RevokedToken{
+			ID:        tokenId,
+			UserID:    userId,
+			ExpiresAt: expiresAt,
+		}
+		return nil
+	})
+}
+
+// RevokeAllUserTokens denylists every outstanding access token for the user,
+// clears their refresh token and deletes their API keys, so nothing minted
+// by someone who had access to the account keeps working.
+func (db *DB) RevokeAllUserTokens(userId int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		user.RefreshToken = ""
+		dbStructure.Users[userId] = user
+
+		dbStructure.pruneTokens(time.Now().UTC())
+		for id, token := range dbStructure.AccessTokens {
+			if token.UserID != userId {
+				continue
+			}
+			dbStructure.RevokedTokens[id] = RevokedToken{
+				ID:        token.ID,
+				UserID:    token.UserID,
+				ExpiresAt: token.ExpiresAt,
+			}
+			delete(dbStructure.AccessTokens, id)
+		}
+		for id, key := range dbStructure.APIKeys {
+			if key.UserID == userId {
+				delete(dbStructure.APIKeys, id)
+			}
+		}
+		return nil
+	})
+}
+
+// pruneTokens drops entries for tokens that have already expired, since an
+// expired JWT is rejected without consulting the revocation list.
+func (dbStructure *DBStructure) pruneTokens(now time.Time) {
+	for id, token := range dbStructure.AccessTokens {
+		if !token.ExpiresAt.After(now) {
+			delete(dbStructure.AccessTokens, id)
+		}
+	}
+	for id, token := range dbStructure.RevokedTokens {
+		if !token.ExpiresAt.After(now) {
+			delete(dbStructure.RevokedTokens, id)
+		}
+	}
+}
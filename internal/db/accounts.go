@@ -0,0 +1,318 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// DeletedAccount describes what DeleteUser removed. BlobKeys are blobs that
+// no remaining media refers to, which the caller should pass to DeleteBlobs.
+type DeletedAccount struct {
+	User     User
+	Chirps   []Chirp
+	BlobKeys []string
+}
+
+// UserExport is everything stored about a user. Credentials are left out:
+// the password hash, refresh token, TOTP secret, recovery codes and the
+// hashes and secrets of API keys, OAuth clients and webhooks.
+type UserExport struct {
+	User              User              `json:"user"`
+	Chirps            []Chirp           `json:"chirps"`
+	Media             []Media           `json:"media"`
+	Follows           []Follow          `json:"follows"`
+	Blocks            []Block           `json:"blocks"`
+	Mutes             []Mute            `json:"mutes"`
+	Notifications     []Notification    `json:"notifications"`
+	NotificationPrefs NotificationPrefs `json:"notification_prefs"`
+	Conversations     []Conversation    `json:"conversations"`
+	Messages          []Message         `json:"messages"`
+	Sessions          []AccessToken     `json:"sessions"`
+	APIKeys           []APIKey          `json:"api_keys"`
+	OAuthClients      []OAuthClient     `json:"oauth_clients"`
+	OAuthConsents     []OAuthConsent    `json:"oauth_consents"`
+	WebhookEndpoints  []WebhookEndpoint `json:"webhook_endpoints"`
+}
+
+// DeleteUser removes a user and everything that belongs to them: chirps,
+// media, sessions, credentials, relationships, notifications and their side
+// of conversations. Outstanding access tokens are revoked rather than
+// forgotten so they stop working immediately. Listeners are told about each
+// published chirp that was removed.
+func (db *DB) DeleteUser(userId int) (DeletedAccount, error) {
+	deleted := DeletedAccount{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		deleted.User = user
+		delete(dbStructure.Users, userId)
+
+		for id, chirp := range dbStructure.Chirps {
+			if chirp.AuthorID == userId {
+				delete(dbStructure.Chirps, id)
+				deleted.Chirps = append(deleted.Chirps, chirp)
+				continue
+			}
+			if slices.Contains(chirp.MentionedIDs, userId) {
+				chirp.MentionedIDs = slices.DeleteFunc(chirp.MentionedIDs, func(id int) bool {
+					return id == userId
+				})
+				dbStructure.Chirps[id] = chirp
+			}
+		}
+
+		blobKeys := []string{}
+		for id, media := range dbStructure.Media {
+			if media.OwnerID == userId {
+				delete(dbStructure.Media, id)
+				blobKeys = append(blobKeys, media.BlobKey, media.ThumbnailKey)
+			}
+		}
+		deleted.BlobKeys = dbStructure.unreferencedBlobs(blobKeys)
+
+		deletedClients := map[string]bool{}
+		for id, client := range dbStructure.OAuthClients {
+			if client.OwnerID == userId {
+				delete(dbStructure.OAuthClients, id)
+				deletedClients[id] = true
+			}
+		}
+
+		now := time.Now().UTC()
+		dbStructure.pruneTokens(now)
+		for id, token := range dbStructure.AccessTokens {
+			if token.UserID != userId && !deletedClients[token.ClientID] {
+				continue
+			}
+			dbStructure.RevokedTokens[id] = RevokedToken{
+				ID:        token.ID,
+				UserID:    token.UserID,
+				ExpiresAt: token.ExpiresAt,
+			}
+			delete(dbStructure.AccessTokens, id)
+		}
+		for id, consent := range dbStructure.OAuthConsents {
+			if consent.UserID == userId || deletedClients[consent.ClientID] {
+				delete(dbStructure.OAuthConsents, id)
+			}
+		}
+		for id, code := range dbStructure.AuthorizationCodes {
+			if code.UserID == userId || deletedClients[code.ClientID] {
+				delete(dbStructure.AuthorizationCodes, id)
+			}
+		}
+		for id, key := range dbStructure.APIKeys {
+			if key.UserID == userId {
+				delete(dbStructure.APIKeys, id)
+			}
+		}
+		for id, reset := range dbStructure.PasswordResets {
+			if reset.UserID == userId {
+				delete(dbStructure.PasswordResets, id)
+			}
+		}
+
+		deletedEndpoints := map[string]bool{}
+		for id, endpoint := range dbStructure.WebhookEndpoints {
+			if endpoint.OwnerID == userId {
+				delete(dbStructure.WebhookEndpoints, id)
+				deletedEndpoints[id] = true
+			}
+		}
+		for id, delivery := range dbStructure.WebhookDeliveries {
+			if deletedEndpoints[delivery.EndpointID] {
+				delete(dbStructure.WebhookDeliveries, id)
+			}
+		}
+
+		for key, follow := range dbStructure.Follows {
+			if follow.FollowerID == userId || follow.FolloweeID == userId {
+				delete(dbStructure.Follows, key)
+			}
+		}
+		for key, block := range dbStructure.Blocks {
+			if block.BlockerID == userId || block.BlockedID == userId {
+				delete(dbStructure.Blocks, key)
+			}
+		}
+		for key, mute := range dbStructure.Mutes {
+			if mute.MuterID == userId || mute.MutedID == userId {
+				delete(dbStructure.Mutes, key)
+			}
+		}
+		for id, notification := range dbStructure.Notifications {
+			if notification.UserID == userId || notification.ActorID == userId {
+				delete(dbStructure.Notifications, id)
+			}
+		}
+		delete(dbStructure.NotificationPrefs, userId)
+
+		// The other participants keep the conversation without the deleted
+		// user's messages, unless nobody would be left to talk to
+		deletedConversations := map[string]bool{}
+		for id, conversation := range dbStructure.Conversations {
+			if !conversation.HasParticipant(userId) {
+				continue
+			}
+			conversation.ParticipantIDs = slices.DeleteFunc(conversation.ParticipantIDs, func(id int) bool {
+				return id == userId
+			})
+			delete(conversation.LastRead, userId)
+			if len(conversation.ParticipantIDs) < 2 {
+				delete(dbStructure.Conversations, id)
+				deletedConversations[id] = true
+				continue
+			}
+			dbStructure.Conversations[id] = conversation
+		}
+		for id, message := range dbStructure.Messages {
+			if message.SenderID == userId || deletedConversations[message.ConversationID] {
+				delete(dbStructure.Messages, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return DeletedAccount{}, err
+	}
+	for _, chirp := range deleted.Chirps {
+		if chirp.Published() {
+			db.notifyChirp(ChirpDeleted, chirp)
+		}
+	}
+	return deleted, nil
+}
+
+// ExportUser collects everything stored about a user for a data export.
+func (db *DB) ExportUser(userId int) (UserExport, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	user, exists := dbStructure.Users[userId]
+	if !exists {
+		return UserExport{}, errors.New("could not find user")
+	}
+	user.Password = nil
+	user.RefreshToken = ""
+	user.VerificationTokenID = ""
+	user.TOTPSecret = ""
+	user.RecoveryCodes = nil
+	user.MFAChallengeID = ""
+
+	export := UserExport{
+		User:              user,
+		Chirps:            []Chirp{},
+		Media:             []Media{},
+		Follows:           []Follow{},
+		Blocks:            []Block{},
+		Mutes:             []Mute{},
+		Notifications:     []Notification{},
+		NotificationPrefs: dbStructure.notificationPrefs(userId),
+		Conversations:     []Conversation{},
+		Messages:          []Message{},
+		Sessions:          []AccessToken{},
+		APIKeys:           []APIKey{},
+		OAuthClients:      []OAuthClient{},
+		OAuthConsents:     []OAuthConsent{},
+		WebhookEndpoints:  []WebhookEndpoint{},
+	}
+
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID == userId {
+			export.Chirps = append(export.Chirps, chirp)
+		}
+	}
+	for _, media := range dbStructure.Media {
+		if media.OwnerID == userId {
+			export.Media = append(export.Media, media)
+		}
+	}
+	for _, follow := range dbStructure.Follows {
+		if follow.FollowerID == userId || follow.FolloweeID == userId {
+			export.Follows = append(export.Follows, follow)
+		}
+	}
+	// Only the user's own blocks and mutes; who has blocked or muted them is
+	// the other user's data
+	for _, block := range dbStructure.Blocks {
+		if block.BlockerID == userId {
+			export.Blocks = append(export.Blocks, block)
+		}
+	}
+	for _, mute := range dbStructure.Mutes {
+		if mute.MuterID == userId {
+			export.Mutes = append(export.Mutes, mute)
+		}
+	}
+	for _, notification := range dbStructure.Notifications {
+		if notification.UserID == userId {
+			export.Notifications = append(export.Notifications, notification)
+		}
+	}
+	conversationIds := map[string]bool{}
+	for id, conversation := range dbStructure.Conversations {
+		if conversation.HasParticipant(userId) {
+			export.Conversations = append(export.Conversations, conversation)
+			conversationIds[id] = true
+		}
+	}
+	for _, message := range dbStructure.Messages {
+		if conversationIds[message.ConversationID] {
+			export.Messages = append(export.Messages, message)
+		}
+	}
+	now := time.Now().UTC()
+	for _, token := range dbStructure.AccessTokens {
+		if token.UserID == userId && token.ExpiresAt.After(now) {
+			export.Sessions = append(export.Sessions, token)
+		}
+	}
+	for _, key := range dbStructure.APIKeys {
+		if key.UserID == userId {
+			key.KeyHash = ""
+			export.APIKeys = append(export.APIKeys, key)
+		}
+	}
+	for _, client := range dbStructure.OAuthClients {
+		if client.OwnerID == userId {
+			client.SecretHash = ""
+			export.OAuthClients = append(export.OAuthClients, client)
+		}
+	}
+	for _, consent := range dbStructure.OAuthConsents {
+		if consent.UserID == userId {
+			export.OAuthConsents = append(export.OAuthConsents, consent)
+		}
+	}
+	for _, endpoint := range dbStructure.WebhookEndpoints {
+		if endpoint.OwnerID == userId {
+			endpoint.Secret = ""
+			export.WebhookEndpoints = append(export.WebhookEndpoints, endpoint)
+		}
+	}
+
+	sortExport(&export)
+	return export, nil
+}
+
+// sortExport orders records by id or creation time so exports are stable.
+func sortExport(export *UserExport) {
+	slices.SortFunc(export.Chirps, func(a, b Chirp) int { return a.ID - b.ID })
+	slices.SortFunc(export.Media, func(a, b Media) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	slices.SortFunc(export.Follows, func(a, b Follow) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	slices.SortFunc(export.Blocks, func(a, b Block) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	slices.SortFunc(export.Mutes, func(a, b Mute) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	slices.SortFunc(export.Notifications, func(a, b Notification) int { return a.ID - b.ID })
+	slices.SortFunc(export.Conversations, func(a, b Conversation) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	slices.SortFunc(export.Messages, func(a, b Message) int { return a.ID - b.ID })
+	slices.SortFunc(export.Sessions, func(a, b AccessToken) int { return a.IssuedAt.Compare(b.IssuedAt) })
+	slices.SortFunc(export.APIKeys, func(a, b APIKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	slices.SortFunc(export.OAuthClients, func(a, b OAuthClient) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	slices.SortFunc(export.OAuthConsents, func(a, b OAuthConsent) int { return a.GrantedAt.Compare(b.GrantedAt) })
+	slices.SortFunc(export.WebhookEndpoints, func(a, b WebhookEndpoint) int { return a.CreatedAt.Compare(b.CreatedAt) })
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCreateUserNeverReusesIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	database, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+
+	first, err := database.CreateUser("first@example.com", "hash", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+	second, err := database.CreateUser("second@example.com", "hash", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+	_, err = database.DeleteUser(second.ID)
+	if err != nil {
+		t.Fatalf("DeleteUser: %s", err)
+	}
+
+	// The counter is persisted, so reopening the file doesn't reset it
+	database, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	third, err := database.CreateUser("third@example.com", "hash", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+	if first.ID != 1 || second.ID != 2 || third.ID != 3 {
+		t.Errorf("got ids %d, %d, %d, want 1, 2, 3", first.ID, second.ID, third.ID)
+	}
+}
+
+func TestEmailsAreUnique(t *testing.T) {
+	database, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+
+	// Signups racing for the same address must not both succeed
+	created := make(chan User, 8)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, err := database.CreateUser("same@example.com", "hash", "")
+			if err == nil {
+				created <- user
+			} else if !errors.Is(err, ErrEmailTaken) {
+				t.Errorf("CreateUser: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(created)
+	if len(created) != 1 {
+		t.Fatalf("%d users were created with the same email", len(created))
+	}
+	first := <-created
+
+	_, err = database.CreateUser("SAME@example.com", "hash", "")
+	if !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("CreateUser with different case = %v, want %v", err, ErrEmailTaken)
+	}
+
+	other, err := database.CreateUser("other@example.com", "hash", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+	err = database.UpdateUser(other.ID, "same@example.com", "hash")
+	if !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("UpdateUser to a taken email = %v, want %v", err, ErrEmailTaken)
+	}
+	err = database.UpdateUser(first.ID, "same@example.com", "new hash")
+	if err != nil {
+		t.Errorf("UpdateUser keeping the same email: %s", err)
+	}
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// APIKey is a long-lived credential for scripts. Only a hash of the key is
+// stored; Prefix is kept so users can tell their keys apart.
+type APIKey struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	KeyHash    string    `json:"key_hash"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+func (db *DB) CreateAPIKey(key APIKey) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		dbStructure.APIKeys[key.ID] = key
+		return nil
+	})
+}
+
+func (db *DB) GetAPIKeysByUser(userId int) ([]APIKey, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []APIKey{}
+	for _, key := range dbStructure.APIKeys {
+		if key.UserID == userId {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// GetAPIKeyByHash finds an unexpired key.
+func (db *DB) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	for _, key := range dbStructure.APIKeys {
+		if key.KeyHash == keyHash {
+			if !key.ExpiresAt.After(time.Now().UTC()) {
+				return APIKey{}, errors.New("api key has expired")
+			}
+			return key, nil
+		}
+	}
+
+	return APIKey{}, errors.New("could not find api key")
+}
+
+func (db *DB) TouchAPIKey(id string, usedAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		key, exists := dbStructure.APIKeys[id]
+		if !exists {
+			return errors.New("could not find api key")
+		}
+		key.LastUsedAt = usedAt
+		dbStructure.APIKeys[id] = key
+		return nil
+	})
+}
+
+func (db *DB) DeleteAPIKey(userId int, id string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		key, exists := dbStructure.APIKeys[id]
+		if !exists || key.UserID != userId {
+			return errors.New("could not find api key")
+		}
+		delete(dbStructure.APIKeys, id)
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+package db
+
+const (
+	ChirpVisibilityPublic    = "public"
+	ChirpVisibilityFollowers = "followers"
+	ChirpVisibilityMentioned = "mentioned"
+)
+
+// Audience is a snapshot of what one viewer is allowed to see. ViewerID is
+// 0 for anonymous viewers. Blocked holds users blocked in either direction.
+type Audience struct {
+	ViewerID  int
+	Following map[int]bool
+	Private   map[int]bool
+	Blocked   map[int]bool
+	Muted     map[int]bool
+}
+
+func (db *DB) GetAudience(viewerId int) (Audience, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Audience{}, err
+	}
+
+	audience := Audience{
+		ViewerID:  viewerId,
+		Following: map[int]bool{},
+		Private:   map[int]bool{},
+		Blocked:   map[int]bool{},
+		Muted:     map[int]bool{},
+	}
+	for _, user := range dbStructure.Users {
+		if user.IsPrivate {
+			audience.Private[user.ID] = true
+		}
+	}
+	if viewerId != 0 {
+		for _, follow := range dbStructure.Follows {
+			if follow.FollowerID == viewerId && follow.Approved() {
+				audience.Following[follow.FolloweeID] = true
+			}
+		}
+		for _, block := range dbStructure.Blocks {
+			if block.BlockerID == viewerId {
+				audience.Blocked[block.BlockedID] = true
+			}
+			if block.BlockedID == viewerId {
+				audience.Blocked[block.BlockerID] = true
+			}
+		}
+		for _, mute := range dbStructure.Mutes {
+			if mute.MuterID == viewerId {
+				audience.Muted[mute.MutedID] = true
+			}
+		}
+	}
+
+	return audience, nil
+}
+
+// CanView decides whether the viewer may see a chirp. Authors always see
+// their own chirps. Everyone else needs the chirp to be published, no block
+// between them and the author, an approved follow if the author is private,
+// and to satisfy the chirp's visibility level.
+func (audience Audience) CanView(chirp Chirp) bool {
+	if audience.ViewerID != 0 && audience.ViewerID == chirp.AuthorID {
+		return true
+	}
+	if !chirp.Published() || audience.Blocked[chirp.AuthorID] {
+		return false
+	}
+	if audience.Private[chirp.AuthorID] && !audience.Following[chirp.AuthorID] {
+		return false
+	}
+
+	switch chirp.Visibility {
+	case "", ChirpVisibilityPublic:
+		return true
+	case ChirpVisibilityFollowers:
+		return audience.Following[chirp.AuthorID]
+	case ChirpVisibilityMentioned:
+		for _, userId := range chirp.MentionedIDs {
+			if audience.ViewerID != 0 && userId == audience.ViewerID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Hides reports whether the chirp should be left out of the viewer's
+// timelines because they muted its author. Muted chirps can still be
+// fetched directly.
+func (audience Audience) Hides(chirp Chirp) bool {
+	return audience.Muted[chirp.AuthorID]
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrBlocked = errors.New("one user has blocked the other")
+
+// Block stops two users from following, replying to or mentioning each
+// other, and hides each other's chirps. It works in both directions no
+// matter who created it.
+type Block struct {
+	BlockerID int       `json:"blocker_id"`
+	BlockedID int       `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Mute hides a user's chirps from the muter's timelines without them
+// knowing. Unlike a block it only affects the muter.
+type Mute struct {
+	MuterID   int       `json:"muter_id"`
+	MutedID   int       `json:"muted_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BlockUser blocks blockedId and removes any follows between the two users.
+func (db *DB) BlockUser(blockerId int, blockedId int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, exists := dbStructure.Users[blockedId]; !exists {
+			return errors.New("could not find user")
+		}
+
+		key := followKey(blockerId, blockedId)
+		if _, exists := dbStructure.Blocks[key]; !exists {
+			dbStructure.Blocks[key] = Block{
+				BlockerID: blockerId,
+				BlockedID: blockedId,
+				CreatedAt: time.Now().UTC(),
+			}
+		}
+		delete(dbStructure.Follows, followKey(blockerId, blockedId))
+		delete(dbStructure.Follows, followKey(blockedId, blockerId))
+		return nil
+	})
+}
+
+func (db *DB) UnblockUser(blockerId int, blockedId int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.Blocks, followKey(blockerId, blockedId))
+		return nil
+	})
+}
+
+// GetBlocks returns the blocks userId has created.
+func (db *DB) GetBlocks(userId int) ([]Block, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := []Block{}
+	for _, block := range dbStructure.Blocks {
+		if block.BlockerID == userId {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks, nil
+}
+
+// IsBlocked reports whether either user has blocked the other.
+func (db *DB) IsBlocked(userId int, otherId int) (bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	return dbStructure.blocked(userId, otherId), nil
+}
+
+func (db *DB) MuteUser(muterId int, mutedId int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, exists := dbStructure.Users[mutedId]; !exists {
+			return errors.New("could not find user")
+		}
+
+		key := followKey(muterId, mutedId)
+		if _, exists := dbStructure.Mutes[key]; exists {
+			return nil
+		}
+		dbStructure.Mutes[key] = Mute{
+			MuterID:   muterId,
+			MutedID:   mutedId,
+			CreatedAt: time.Now().UTC(),
+		}
+		return nil
+	})
+}
+
+func (db *DB) UnmuteUser(muterId int, mutedId int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.Mutes, followKey(muterId, mutedId))
+		return nil
+	})
+}
+
+func (db *DB) GetMutes(userId int) ([]Mute, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	mutes := []Mute{}
+	for _, mute := range dbStructure.Mutes {
+		if mute.MuterID == userId {
+			mutes = append(mutes, mute)
+		}
+	}
+
+	return mutes, nil
+}
+
+func (dbStructure *DBStructure) blocked(userId int, otherId int) bool {
+	_, blocked := dbStructure.Blocks[followKey(userId, otherId)]
+	_, blockedBy := dbStructure.Blocks[followKey(otherId, userId)]
+	return blocked || blockedBy
+}
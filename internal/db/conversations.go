@@ -0,0 +1,260 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"time"
+)
+
+var ErrNotParticipant = errors.New("user is not in this conversation")
+
+// Conversation is a private thread between two or more users. LastRead holds
+// each participant's read receipt: the ID of the newest message they've
+// read.
+type Conversation struct {
+	ID             string      `json:"id"`
+	ParticipantIDs []int       `json:"participant_ids"`
+	LastRead       map[int]int `json:"last_read"`
+	CreatedAt      time.Time   `json:"created_at"`
+	LastMessageAt  time.Time   `json:"last_message_at"`
+}
+
+type Message struct {
+	ID             int       `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (conversation Conversation) HasParticipant(userId int) bool {
+	return slices.Contains(conversation.ParticipantIDs, userId)
+}
+
+// GetOrCreateConversation returns the conversation between exactly these
+// participants, creating it with id if there isn't one. It returns
+// ErrBlocked if any two participants have a block between them.
+func (db *DB) GetOrCreateConversation(id string, participantIds []int) (Conversation, error) {
+	conversation := Conversation{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		participants := slices.Clone(participantIds)
+		slices.Sort(participants)
+		participants = slices.Compact(participants)
+		for i, userId := range participants {
+			if _, exists := dbStructure.Users[userId]; !exists {
+				return errors.New("could not find user")
+			}
+			for _, otherId := range participants[i+1:] {
+				if dbStructure.blocked(userId, otherId) {
+					return ErrBlocked
+				}
+			}
+		}
+
+		for _, existing := range dbStructure.Conversations {
+			if slices.Equal(existing.ParticipantIDs, participants) {
+				conversation = existing
+				return nil
+			}
+		}
+
+		now := time.Now().UTC()
+		conversation = Conversation{
+			ID:             id,
+			ParticipantIDs: participants,
+			LastRead:       map[int]int{},
+			CreatedAt:      now,
+			LastMessageAt:  now,
+		}
+		dbStructure.Conversations[id] = conversation
+		return nil
+	})
+	if err != nil {
+		return Conversation{}, err
+	}
+	return conversation, nil
+}
+
+// GetConversation returns the conversation if userId is a participant.
+func (db *DB) GetConversation(id string, userId int) (Conversation, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	conversation, exists := dbStructure.Conversations[id]
+	if !exists || !conversation.HasParticipant(userId) {
+		return Conversation{}, ErrNotParticipant
+	}
+
+	return conversation, nil
+}
+
+// GetConversations returns userId's conversations, most recently active
+// first.
+func (db *DB) GetConversations(userId int) ([]Conversation, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := []Conversation{}
+	for _, conversation := range dbStructure.Conversations {
+		if conversation.HasParticipant(userId) {
+			conversations = append(conversations, conversation)
+		}
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversations[i].LastMessageAt.After(conversations[j].LastMessageAt)
+	})
+
+	return conversations, nil
+}
+
+// CreateMessage sends a message. Sending marks everything up to the new
+// message as read for the sender. It returns ErrBlocked if the sender has a
+// block with any other participant.
+func (db *DB) CreateMessage(conversationId string, senderId int, body string) (Message, error) {
+	message := Message{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		conversation, exists := dbStructure.Conversations[conversationId]
+		if !exists || !conversation.HasParticipant(senderId) {
+			return ErrNotParticipant
+		}
+		for _, userId := range conversation.ParticipantIDs {
+			if userId != senderId && dbStructure.blocked(senderId, userId) {
+				return ErrBlocked
+			}
+		}
+
+		id := 1
+		for existingId := range dbStructure.Messages {
+			if existingId >= id {
+				id = existingId + 1
+			}
+		}
+
+		message = Message{
+			ID:             id,
+			ConversationID: conversationId,
+			SenderID:       senderId,
+			Body:           body,
+			CreatedAt:      time.Now().UTC(),
+		}
+		dbStructure.Messages[id] = message
+
+		if conversation.LastRead == nil {
+			conversation.LastRead = map[int]int{}
+		}
+		conversation.LastRead[senderId] = id
+		conversation.LastMessageAt = message.CreatedAt
+		dbStructure.Conversations[conversationId] = conversation
+		return nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	return message, nil
+}
+
+// GetMessages returns a conversation's messages, oldest first.
+func (db *DB) GetMessages(conversationId string) ([]Message, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []Message{}
+	for _, message := range dbStructure.Messages {
+		if message.ConversationID == conversationId {
+			messages = append(messages, message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+
+	return messages, nil
+}
+
+// MarkConversationRead moves userId's read receipt forward to messageId, or
+// to the newest message when messageId is 0. Receipts never move backwards.
+func (db *DB) MarkConversationRead(conversationId string, userId int, messageId int) (Conversation, error) {
+	conversation := Conversation{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		existing, exists := dbStructure.Conversations[conversationId]
+		if !exists || !existing.HasParticipant(userId) {
+			return ErrNotParticipant
+		}
+		conversation = existing
+
+		latest := 0
+		for _, message := range dbStructure.Messages {
+			if message.ConversationID != conversationId {
+				continue
+			}
+			if messageId == 0 || message.ID <= messageId {
+				latest = max(latest, message.ID)
+			}
+		}
+
+		if conversation.LastRead == nil {
+			conversation.LastRead = map[int]int{}
+		}
+		if latest <= conversation.LastRead[userId] {
+			return nil
+		}
+		conversation.LastRead[userId] = latest
+		dbStructure.Conversations[conversationId] = conversation
+		return nil
+	})
+	if err != nil {
+		return Conversation{}, err
+	}
+	return conversation, nil
+}
+
+// UnreadMessageCounts returns how many messages from other participants each
+// of userId's conversations has past their read receipt.
+func (db *DB) UnreadMessageCounts(userId int) (map[string]int, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, message := range dbStructure.Messages {
+		conversation, exists := dbStructure.Conversations[message.ConversationID]
+		if !exists || !conversation.HasParticipant(userId) || message.SenderID == userId {
+			continue
+		}
+		if message.ID > conversation.LastRead[userId] {
+			counts[message.ConversationID]++
+		}
+	}
+
+	return counts, nil
+}
+
+// LastMessages returns the newest message in each of userId's conversations
+// that has any, keyed by conversation id.
+func (db *DB) LastMessages(userId int) (map[string]Message, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	last := map[string]Message{}
+	for _, message := range dbStructure.Messages {
+		conversation, exists := dbStructure.Conversations[message.ConversationID]
+		if !exists || !conversation.HasParticipant(userId) {
+			continue
+		}
+		if message.ID > last[message.ConversationID].ID {
+			last[message.ConversationID] = message
+		}
+	}
+
+	return last, nil
+}
@@ -4,33 +4,105 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"sort"
+	"strings"
 	"sync"
-
-	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 type DB struct {
-	path string
-	mu   *sync.RWMutex
+	path      string
+	mu        *sync.RWMutex
+	listeners []ChirpListener
 }
 
+const (
+	ChirpCreated = "created"
+	ChirpUpdated = "updated"
+	ChirpDeleted = "deleted"
+)
+
+const (
+	ChirpStatusPublished = "published"
+	ChirpStatusDraft     = "draft"
+	ChirpStatusScheduled = "scheduled"
+)
+
+// ChirpListener is called after a chirp has been created, updated or deleted.
+type ChirpListener func(event string, chirp Chirp)
+
 type DBStructure struct {
-	Chirps map[int]Chirp `json:"chirps"`
-	Users  map[int]User  `json:"users"`
+	Version            int                          `json:"version"`
+	NextUserID         int                          `json:"next_user_id"`
+	Chirps             map[int]Chirp                `json:"chirps"`
+	Users              map[int]User                 `json:"users"`
+	AccessTokens       map[string]AccessToken       `json:"access_tokens"`
+	RevokedTokens      map[string]RevokedToken      `json:"revoked_tokens"`
+	PasswordResets     map[string]PasswordReset     `json:"password_resets"`
+	LoginAttempts      map[string]LoginAttempt      `json:"login_attempts"`
+	OAuthClients       map[string]OAuthClient       `json:"oauth_clients"`
+	OAuthConsents      map[string]OAuthConsent      `json:"oauth_consents"`
+	AuthorizationCodes map[string]AuthorizationCode `json:"authorization_codes"`
+	APIKeys            map[string]APIKey            `json:"api_keys"`
+	ProcessedWebhooks  map[string]ProcessedWebhook  `json:"processed_webhooks"`
+	WebhookEndpoints   map[string]WebhookEndpoint   `json:"webhook_endpoints"`
+	WebhookDeliveries  map[string]WebhookDelivery   `json:"webhook_deliveries"`
+	Follows            map[string]Follow            `json:"follows"`
+	Notifications      map[int]Notification         `json:"notifications"`
+	NotificationPrefs  map[int]NotificationPrefs    `json:"notification_prefs"`
+	Media              map[string]Media             `json:"media"`
+	LinkPreviews       map[string]LinkPreview       `json:"link_previews"`
+	Blocks             map[string]Block             `json:"blocks"`
+	Mutes              map[string]Mute              `json:"mutes"`
+	Conversations      map[string]Conversation      `json:"conversations"`
+	Messages           map[int]Message              `json:"messages"`
 }
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorID int    `json:"author_id"`
+	ID        int       `json:"id"`
+	Body      string    `json:"body"`
+	AuthorID  int       `json:"author_id"`
+	ReplyToID int       `json:"reply_to_id"`
+	MediaIDs  []string  `json:"media_ids"`
+	URLs      []string  `json:"urls"`
+	Status    string    `json:"status"`
+	PublishAt time.Time `json:"publish_at"`
+
+	Visibility   string `json:"visibility"`
+	MentionedIDs []int  `json:"mentioned_ids"`
+
+	CreatedAt time.Time `json:"created_at"`
+	EditedAt  time.Time `json:"edited_at"`
 }
 
+// Published reports whether the chirp is visible to everyone. Chirps written
+// before drafts existed have no status and are published.
+func (chirp Chirp) Published() bool {
+	return chirp.Status == "" || chirp.Status == ChirpStatusPublished
+}
+
+// User is the persisted account record. It holds the password hash, refresh
+// token and TOTP secret, so handlers must convert it to a response type
+// rather than sending it to clients.
 type User struct {
-	ID           int    `json:"id"`
-	Email        string `json:"email"`
-	Password     []byte `json:"password"`
-	RefreshToken string `json:"refresh_token"`
-	IsRed        bool   `json:"is_chirpy_red"`
+	ID                  int          `json:"id"`
+	Email               string       `json:"email"`
+	Password            []byte       `json:"password"`
+	RefreshToken        string       `json:"refresh_token"`
+	IsRed               bool         `json:"is_chirpy_red"`
+	IsVerified          bool         `json:"is_verified"`
+	VerificationTokenID string       `json:"verification_token_id"`
+	TOTPSecret          string       `json:"totp_secret"`
+	TOTPEnabled         bool         `json:"totp_enabled"`
+	TOTPLastCounter     int64        `json:"totp_last_counter"`
+	RecoveryCodes       []string     `json:"recovery_codes"`
+	MFAChallengeID      string       `json:"mfa_challenge_id"`
+	Subscription        Subscription `json:"subscription"`
+	IsPrivate           bool         `json:"is_private"`
+	Handle              string       `json:"handle"`
+	DisplayName         string       `json:"display_name"`
+	Bio                 string       `json:"bio"`
+	AvatarMediaID       string       `json:"avatar_media_id"`
 }
 
 func NewDB(path string) (*DB, error) {
@@ -43,41 +115,144 @@ func NewDB(path string) (*DB, error) {
 	return db, err
 }
 
-func (db *DB) CreateChirp(authorId int, body string) (Chirp, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return Chirp{}, nil
+// AddChirpListener registers a listener for chirp changes. It must be called
+// before the server starts handling requests.
+func (db *DB) AddChirpListener(listener ChirpListener) {
+	db.listeners = append(db.listeners, listener)
+}
+
+func (db *DB) notifyChirp(event string, chirp Chirp) {
+	for _, listener := range db.listeners {
+		listener(event, chirp)
 	}
+}
 
-	id := len(dbStructure.Chirps) + 1
+// CreateChirp stores a new chirp, assigning its ID and creation time. Media
+// in MediaIDs must belong to the author and not be attached elsewhere.
+// Listeners are only told about the chirp once it is published.
+func (db *DB) CreateChirp(chirp Chirp) (Chirp, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		for _, mediaId := range chirp.MediaIDs {
+			media, exists := dbStructure.Media[mediaId]
+			if !exists || media.OwnerID != chirp.AuthorID || media.ChirpID != 0 {
+				return errors.New("could not attach media " + mediaId)
+			}
+		}
 
-	chirp := Chirp{
-		ID:       id,
-		Body:     body,
-		AuthorID: authorId,
-	}
-	dbStructure.Chirps[id] = chirp
+		// Deleted chirps leave gaps, so take the next id after the highest
+		// one rather than reusing an id that clients may have already seen
+		id := 1
+		for existingId := range dbStructure.Chirps {
+			if existingId >= id {
+				id = existingId + 1
+			}
+		}
 
-	err = db.writeDB(dbStructure)
+		chirp.ID = id
+		chirp.CreatedAt = time.Now().UTC()
+		if chirp.Status == "" {
+			chirp.Status = ChirpStatusPublished
+		}
+		dbStructure.Chirps[id] = chirp
+		for _, mediaId := range chirp.MediaIDs {
+			media := dbStructure.Media[mediaId]
+			media.ChirpID = id
+			dbStructure.Media[mediaId] = media
+		}
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
+	if chirp.Published() {
+		db.notifyChirp(ChirpCreated, chirp)
+	}
 
 	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(chirpId int) error {
+func (db *DB) GetChirp(chirpId int) (Chirp, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
-		return err
+		return Chirp{}, err
+	}
+
+	chirp, exists := dbStructure.Chirps[chirpId]
+	if !exists {
+		return Chirp{}, errors.New("could not find chirp")
 	}
 
-	delete(dbStructure.Chirps, chirpId)
-	err = db.writeDB(dbStructure)
+	return chirp, nil
+}
+
+// UpdateChirp replaces a chirp's body. Unless allowPublished is set, only
+// drafts and scheduled chirps can be changed and ErrChirpPublished is returned
+// for the rest; this is checked under the same lock as the write, so a chirp
+// published in the meantime can't slip through.
+func (db *DB) UpdateChirp(chirpId int, body string, urls []string, mentionedIds []int, allowPublished bool) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		existing, exists := dbStructure.Chirps[chirpId]
+		if !exists {
+			return errors.New("could not find chirp")
+		}
+		if existing.Published() && !allowPublished {
+			return ErrChirpPublished
+		}
+		chirp = existing
+		chirp.Body = body
+		chirp.URLs = urls
+		chirp.MentionedIDs = mentionedIds
+		if chirp.Published() {
+			chirp.EditedAt = time.Now().UTC()
+		}
+		dbStructure.Chirps[chirpId] = chirp
+		return nil
+	})
 	if err != nil {
-		return err
+		return Chirp{}, err
 	}
-	return nil
+	if chirp.Published() {
+		db.notifyChirp(ChirpUpdated, chirp)
+	}
+
+	return chirp, nil
+}
+
+// DeleteChirp deletes the chirp along with its media, except media that is
+// also its author's avatar, which is only detached. It returns the keys of
+// blobs that no remaining media refers to, for the caller to pass to
+// DeleteBlobs.
+func (db *DB) DeleteChirp(chirpId int) ([]string, error) {
+	chirp := Chirp{}
+	exists := false
+	blobKeys := []string{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		chirp, exists = dbStructure.Chirps[chirpId]
+		delete(dbStructure.Chirps, chirpId)
+
+		for id, media := range dbStructure.Media {
+			if media.ChirpID != chirpId {
+				continue
+			}
+			if dbStructure.isAvatar(id) {
+				media.ChirpID = 0
+				dbStructure.Media[id] = media
+				continue
+			}
+			delete(dbStructure.Media, id)
+			blobKeys = append(blobKeys, media.BlobKey, media.ThumbnailKey)
+		}
+		blobKeys = dbStructure.unreferencedBlobs(blobKeys)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if exists && chirp.Published() {
+		db.notifyChirp(ChirpDeleted, chirp)
+	}
+	return blobKeys, nil
 }
 
 func (db *DB) GetChirps() ([]Chirp, error) {
@@ -103,17 +278,27 @@ func (db *DB) GetUser(email string) (User, error) {
 	existingUser := User{}
 	for _, user := range dbStructure.Users {
 		if user.Email == email {
-			existingUser.Email = user.Email
-			existingUser.ID = user.ID
-			existingUser.Password = user.Password
-			existingUser.RefreshToken = user.RefreshToken
-			existingUser.IsRed = user.IsRed
+			existingUser = user
 		}
 	}
 
 	return existingUser, nil
 }
 
+func (db *DB) GetUserByID(id int) (User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, exists := dbStructure.Users[id]
+	if !exists {
+		return User{}, errors.New("could not find user")
+	}
+
+	return user, nil
+}
+
 func (db *DB) GetRefreshToken(refreshToken string) (int, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
@@ -129,127 +314,114 @@ func (db *DB) GetRefreshToken(refreshToken string) (int, error) {
 }
 
 func (db *DB) RefreshToken(id int, refreshToken string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	for i := range dbStructure.Users {
-		if dbStructure.Users[i].ID == id {
-			dbStructure.Users[i] = User{
-				ID:           dbStructure.Users[i].ID,
-				Email:        dbStructure.Users[i].Email,
-				Password:     dbStructure.Users[i].Password,
-				RefreshToken: refreshToken,
-				IsRed:        dbStructure.Users[i].IsRed,
+	return db.update(func(dbStructure *DBStructure) error {
+		for i := range dbStructure.Users {
+			if dbStructure.Users[i].ID == id {
+				user := dbStructure.Users[i]
+				user.RefreshToken = refreshToken
+				dbStructure.Users[i] = user
 			}
 		}
-	}
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
-
-	return nil
+		return nil
+	})
 }
 
 func (db *DB) RevokeToken(refreshToken string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	for i := range dbStructure.Users {
-		if dbStructure.Users[i].RefreshToken == refreshToken {
-			dbStructure.Users[i] = User{
-				ID:           dbStructure.Users[i].ID,
-				Password:     dbStructure.Users[i].Password,
-				RefreshToken: "",
-				IsRed:        dbStructure.Users[i].IsRed,
+	return db.update(func(dbStructure *DBStructure) error {
+		for i := range dbStructure.Users {
+			if dbStructure.Users[i].RefreshToken == refreshToken {
+				user := dbStructure.Users[i]
+				user.RefreshToken = ""
+				dbStructure.Users[i] = user
 			}
 		}
-	}
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
-
-	return nil
+		return nil
+	})
 }
 
-func (db *DB) CreateUser(email string, password string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return User{}, err
-	}
-
-	id := len(dbStructure.Users) + 1
-	user := User{
-		ID:       id,
-		Email:    email,
-		Password: hashedPassword,
-		IsRed:    false,
-	}
-	dbStructure.Users[id] = user
-
-	err = db.writeDB(dbStructure)
+// ErrEmailTaken is returned when another account already uses an email
+// address. Login, password resets and lockouts look users up by email, so it
+// has to identify a single account.
+var ErrEmailTaken = errors.New("email is already taken")
+
+// CreateUser creates a user with the given handle, or with a generated one
+// like "user12" if handle is empty. It returns ErrEmailTaken or
+// ErrHandleTaken if someone already has the email or handle.
+func (db *DB) CreateUser(email string, hashedPassword string, handle string) (User, error) {
+	user := User{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.emailTaken(email, 0) {
+			return ErrEmailTaken
+		}
+		// Ids are never reused, so nothing that still refers to a deleted
+		// account can end up pointing at a new one
+		id := dbStructure.NextUserID
+		if handle == "" {
+			handle = dbStructure.defaultHandle(id)
+		} else if dbStructure.handleTaken(handle, 0) {
+			return ErrHandleTaken
+		}
+		user = User{
+			ID:       id,
+			Email:    email,
+			Password: []byte(hashedPassword),
+			IsRed:    false,
+			Handle:   handle,
+		}
+		dbStructure.Users[id] = user
+		dbStructure.NextUserID++
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 	return user, nil
 }
 
+// UpdateUser changes a user's email and password. It returns ErrEmailTaken
+// if another user has the email.
 func (db *DB) UpdateUser(id int, email string, hashedPassword string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	for i := range dbStructure.Users {
-		if dbStructure.Users[i].ID == id {
-			dbStructure.Users[i] = User{
-				Email:        email,
-				Password:     []byte(hashedPassword),
-				ID:           id,
-				RefreshToken: dbStructure.Users[i].RefreshToken,
+	return db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.emailTaken(email, id) {
+			return ErrEmailTaken
+		}
+		for i := range dbStructure.Users {
+			if dbStructure.Users[i].ID == id {
+				user := dbStructure.Users[i]
+				if user.Email != email {
+					user.IsVerified = false
+					user.VerificationTokenID = ""
+				}
+				user.Email = email
+				user.Password = []byte(hashedPassword)
+				dbStructure.Users[i] = user
 			}
 		}
-	}
-
-	db.writeDB(dbStructure)
-	return nil
+		return nil
+	})
 }
 
-func (db *DB) UpgradeUser(userId int) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
+func (db *DB) UpdatePassword(id int, hashedPassword string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[id]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		user.Password = []byte(hashedPassword)
+		dbStructure.Users[id] = user
+		return nil
+	})
+}
 
-	for i := range dbStructure.Users {
-		if dbStructure.Users[i].ID == userId {
-			dbStructure.Users[i] = User{
-				ID:           dbStructure.Users[i].ID,
-				Password:     dbStructure.Users[i].Password,
-				Email:        dbStructure.Users[i].Email,
-				RefreshToken: dbStructure.Users[i].RefreshToken,
-				IsRed:        true,
-			}
-			err = db.writeDB(dbStructure)
-			if err != nil {
-				return err
-			}
-			return nil
+// emailTaken reports whether a user other than exceptId has email, ignoring
+// case.
+func (dbStructure *DBStructure) emailTaken(email string, exceptId int) bool {
+	for _, user := range dbStructure.Users {
+		if user.ID != exceptId && strings.EqualFold(user.Email, email) {
+			return true
 		}
 	}
-
-	return errors.New("could not find user")
+	return false
 }
 
 func (db *DB) ensureDB() error {
@@ -257,14 +429,42 @@ func (db *DB) ensureDB() error {
 	if errors.Is(err, os.ErrNotExist) {
 		return db.createDB()
 	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	// Save any migrations applied while loading
+	return db.update(func(dbStructure *DBStructure) error {
+		return nil
+	})
 }
 
 func (db *DB) loadDB() (DBStructure, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.readFile()
+}
+
+// update loads the database, applies fn and writes the result, holding the
+// lock throughout so no other write can land in between. Nothing is written
+// if fn returns an error.
+func (db *DB) update(fn func(dbStructure *DBStructure) error) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	dbStructure, err := db.readFile()
+	if err != nil {
+		return err
+	}
+	err = fn(&dbStructure)
+	if err != nil {
+		return err
+	}
+	return db.writeFile(dbStructure)
+}
+
+func (db *DB) readFile() (DBStructure, error) {
 	dbStructure := DBStructure{}
 	data, err := os.ReadFile(db.path)
 	if errors.Is(err, os.ErrNotExist) {
@@ -275,15 +475,147 @@ func (db *DB) loadDB() (DBStructure, error) {
 	if err != nil {
 		return dbStructure, err
 	}
+	dbStructure.ensureMaps()
+	dbStructure.migrate()
 
 	return dbStructure, nil
 }
 
-func (db *DB) createDB() error {
-	dbStructure := DBStructure{
-		Chirps: map[int]Chirp{},
-		Users:  map[int]User{},
+// schemaVersion is the version written by this build. Bump it when adding a
+// step to migrate.
+const schemaVersion = 4
+
+// migrate upgrades data written by older versions of chirpy. It runs on every
+// load, and NewDB saves the result straight away so steps that depend on the
+// time they run are only applied once.
+func (dbStructure *DBStructure) migrate() {
+	if dbStructure.Version < 1 {
+		// Accounts from before email verification were never sent a token;
+		// grandfather them instead of locking them out of posting
+		for id, user := range dbStructure.Users {
+			if !user.IsVerified && user.VerificationTokenID == "" {
+				user.IsVerified = true
+				dbStructure.Users[id] = user
+			}
+		}
+	}
+	if dbStructure.Version < 2 {
+		// Users upgraded before subscriptions were tracked have no status,
+		// which would otherwise keep them on Chirpy Red forever
+		now := time.Now().UTC()
+		for id, user := range dbStructure.Users {
+			if user.IsRed && user.Subscription.Status == "" {
+				dbStructure.Users[id] = user.withSubscription(Subscription{
+					Plan:             legacyPlan,
+					Status:           SubscriptionActive,
+					CurrentPeriodEnd: now.Add(legacyPeriod),
+				}, now)
+			}
+		}
+	}
+	if dbStructure.Version < 3 {
+		// Users from before profiles have no handle and couldn't be
+		// mentioned or looked up; give them the one new users would get
+		ids := []int{}
+		for id, user := range dbStructure.Users {
+			if user.Handle == "" {
+				ids = append(ids, id)
+			}
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			user := dbStructure.Users[id]
+			user.Handle = dbStructure.defaultHandle(id)
+			dbStructure.Users[id] = user
+		}
+	}
+	if dbStructure.Version < 4 {
+		// User ids used to be the highest existing id plus one, which hands a
+		// deleted user's id to the next signup. The best that can be done for
+		// ids already lost that way is to continue from the highest one left
+		dbStructure.NextUserID = 1
+		for id := range dbStructure.Users {
+			dbStructure.NextUserID = max(dbStructure.NextUserID, id+1)
+		}
+	}
+	dbStructure.Version = schemaVersion
+}
+
+// ensureMaps initializes collections that may be missing from database files
+// written by older versions of chirpy.
+func (dbStructure *DBStructure) ensureMaps() {
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = map[int]Chirp{}
+	}
+	if dbStructure.Users == nil {
+		dbStructure.Users = map[int]User{}
+	}
+	if dbStructure.AccessTokens == nil {
+		dbStructure.AccessTokens = map[string]AccessToken{}
+	}
+	if dbStructure.RevokedTokens == nil {
+		dbStructure.RevokedTokens = map[string]RevokedToken{}
+	}
+	if dbStructure.PasswordResets == nil {
+		dbStructure.PasswordResets = map[string]PasswordReset{}
+	}
+	if dbStructure.LoginAttempts == nil {
+		dbStructure.LoginAttempts = map[string]LoginAttempt{}
+	}
+	if dbStructure.OAuthClients == nil {
+		dbStructure.OAuthClients = map[string]OAuthClient{}
 	}
+	if dbStructure.OAuthConsents == nil {
+		dbStructure.OAuthConsents = map[string]OAuthConsent{}
+	}
+	if dbStructure.AuthorizationCodes == nil {
+		dbStructure.AuthorizationCodes = map[string]AuthorizationCode{}
+	}
+	if dbStructure.APIKeys == nil {
+		dbStructure.APIKeys = map[string]APIKey{}
+	}
+	if dbStructure.ProcessedWebhooks == nil {
+		dbStructure.ProcessedWebhooks = map[string]ProcessedWebhook{}
+	}
+	if dbStructure.WebhookEndpoints == nil {
+		dbStructure.WebhookEndpoints = map[string]WebhookEndpoint{}
+	}
+	if dbStructure.WebhookDeliveries == nil {
+		dbStructure.WebhookDeliveries = map[string]WebhookDelivery{}
+	}
+	if dbStructure.Follows == nil {
+		dbStructure.Follows = map[string]Follow{}
+	}
+	if dbStructure.Notifications == nil {
+		dbStructure.Notifications = map[int]Notification{}
+	}
+	if dbStructure.NotificationPrefs == nil {
+		dbStructure.NotificationPrefs = map[int]NotificationPrefs{}
+	}
+	if dbStructure.Media == nil {
+		dbStructure.Media = map[string]Media{}
+	}
+	if dbStructure.LinkPreviews == nil {
+		dbStructure.LinkPreviews = map[string]LinkPreview{}
+	}
+	if dbStructure.Blocks == nil {
+		dbStructure.Blocks = map[string]Block{}
+	}
+	if dbStructure.Mutes == nil {
+		dbStructure.Mutes = map[string]Mute{}
+	}
+	if dbStructure.Conversations == nil {
+		dbStructure.Conversations = map[string]Conversation{}
+	}
+	if dbStructure.Messages == nil {
+		dbStructure.Messages = map[int]Message{}
+	}
+}
+
+func (db *DB) createDB() error {
+	dbStructure := DBStructure{}
+	dbStructure.ensureMaps()
+	dbStructure.migrate()
 	return db.writeDB(dbStructure)
 }
 
@@ -291,6 +623,10 @@ func (db *DB) writeDB(dbStructure DBStructure) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	return db.writeFile(dbStructure)
+}
+
+func (db *DB) writeFile(dbStructure DBStructure) error {
 	data, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
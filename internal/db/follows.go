@@ -0,0 +1,138 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	FollowApproved = "approved"
+	FollowPending  = "pending"
+)
+
+// Follow is a follow relationship. Following a private account creates a
+// pending follow that the account has to approve.
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Approved reports whether the follow is in effect. Follows created before
+// private accounts existed have no status and are approved.
+func (follow Follow) Approved() bool {
+	return follow.Status == "" || follow.Status == FollowApproved
+}
+
+func followKey(followerId int, followeeId int) string {
+	return strconv.Itoa(followerId) + ":" + strconv.Itoa(followeeId)
+}
+
+// FollowUser records that followerId follows followeeId, pending approval if
+// the followee is private. created is false if the follow already existed.
+// It returns ErrBlocked if either user has blocked the other.
+func (db *DB) FollowUser(followerId int, followeeId int) (follow Follow, created bool, err error) {
+	err = db.update(func(dbStructure *DBStructure) error {
+		followee, exists := dbStructure.Users[followeeId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		if dbStructure.blocked(followerId, followeeId) {
+			return ErrBlocked
+		}
+
+		key := followKey(followerId, followeeId)
+		if existing, exists := dbStructure.Follows[key]; exists {
+			follow = existing
+			return nil
+		}
+		follow = Follow{
+			FollowerID: followerId,
+			FolloweeID: followeeId,
+			Status:     FollowApproved,
+			CreatedAt:  time.Now().UTC(),
+		}
+		if followee.IsPrivate {
+			follow.Status = FollowPending
+		}
+		dbStructure.Follows[key] = follow
+		created = true
+		return nil
+	})
+	if err != nil {
+		return Follow{}, false, err
+	}
+	return follow, created, nil
+}
+
+func (db *DB) UnfollowUser(followerId int, followeeId int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.Follows, followKey(followerId, followeeId))
+		return nil
+	})
+}
+
+func (db *DB) IsFollowing(followerId int, followeeId int) (bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	follow, exists := dbStructure.Follows[followKey(followerId, followeeId)]
+	return exists && follow.Approved(), nil
+}
+
+// GetPendingFollows returns the follow requests waiting on followeeId.
+func (db *DB) GetPendingFollows(followeeId int) ([]Follow, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	follows := []Follow{}
+	for _, follow := range dbStructure.Follows {
+		if follow.FolloweeID == followeeId && !follow.Approved() {
+			follows = append(follows, follow)
+		}
+	}
+
+	return follows, nil
+}
+
+func (db *DB) ApproveFollow(followerId int, followeeId int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		key := followKey(followerId, followeeId)
+		follow, exists := dbStructure.Follows[key]
+		if !exists || follow.Approved() {
+			return errors.New("could not find follow request")
+		}
+		follow.Status = FollowApproved
+		dbStructure.Follows[key] = follow
+		return nil
+	})
+}
+
+// SetUserPrivate changes whether an account is private. Making an account
+// public approves every pending request, since approval is no longer needed.
+func (db *DB) SetUserPrivate(userId int, private bool) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		user.IsPrivate = private
+		dbStructure.Users[userId] = user
+
+		if !private {
+			for key, follow := range dbStructure.Follows {
+				if follow.FolloweeID == userId && !follow.Approved() {
+					follow.Status = FollowApproved
+					dbStructure.Follows[key] = follow
+				}
+			}
+		}
+		return nil
+	})
+}
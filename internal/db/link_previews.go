@@ -0,0 +1,87 @@
+package db
+
+import (
+	"slices"
+	"time"
+)
+
+// LinkPreview caches the metadata fetched for a URL. Failed fetches are
+// cached too, with Error set, so a broken link isn't refetched for every
+// chirp that contains it.
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Image       string    `json:"image"`
+	SiteName    string    `json:"site_name"`
+	Error       string    `json:"error"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+func (db *DB) GetLinkPreview(url string) (LinkPreview, bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return LinkPreview{}, false, err
+	}
+
+	preview, exists := dbStructure.LinkPreviews[url]
+	return preview, exists, nil
+}
+
+// GetLinkPreviews returns the cached previews for the URLs that have one.
+func (db *DB) GetLinkPreviews(urls []string) (map[string]LinkPreview, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	previews := map[string]LinkPreview{}
+	for _, url := range urls {
+		if preview, exists := dbStructure.LinkPreviews[url]; exists {
+			previews[url] = preview
+		}
+	}
+
+	return previews, nil
+}
+
+// SaveLinkPreview caches a preview. Once more than limit are cached, the
+// ones fetched longest ago are evicted, starting with URLs that no chirp
+// links to any more.
+func (db *DB) SaveLinkPreview(preview LinkPreview, limit int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		dbStructure.LinkPreviews[preview.URL] = preview
+		dbStructure.evictLinkPreviews(limit)
+		return nil
+	})
+}
+
+func (dbStructure *DBStructure) evictLinkPreviews(limit int) {
+	excess := len(dbStructure.LinkPreviews) - limit
+	if excess <= 0 {
+		return
+	}
+
+	linked := map[string]bool{}
+	for _, chirp := range dbStructure.Chirps {
+		for _, url := range chirp.URLs {
+			linked[url] = true
+		}
+	}
+	previews := make([]LinkPreview, 0, len(dbStructure.LinkPreviews))
+	for _, preview := range dbStructure.LinkPreviews {
+		previews = append(previews, preview)
+	}
+	slices.SortFunc(previews, func(a, b LinkPreview) int {
+		if linked[a.URL] != linked[b.URL] {
+			if linked[a.URL] {
+				return 1
+			}
+			return -1
+		}
+		return a.FetchedAt.Compare(b.FetchedAt)
+	})
+	for _, preview := range previews[:excess] {
+		delete(dbStructure.LinkPreviews, preview.URL)
+	}
+}
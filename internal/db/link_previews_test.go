@@ -0,0 +1,44 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveLinkPreviewEvictsOldestUnlinkedFirst(t *testing.T) {
+	database, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	_, err = database.CreateChirp(Chirp{Body: "see https://linked.example", URLs: []string{"https://linked.example"}})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	urls := []string{"https://linked.example", "https://old.example", "https://middle.example", "https://new.example"}
+	for i, url := range urls {
+		err = database.SaveLinkPreview(LinkPreview{URL: url, FetchedAt: start.Add(time.Duration(i) * time.Hour)}, 3)
+		if err != nil {
+			t.Fatalf("SaveLinkPreview(%s): %s", url, err)
+		}
+	}
+
+	previews, err := database.GetLinkPreviews(urls)
+	if err != nil {
+		t.Fatalf("GetLinkPreviews: %s", err)
+	}
+	if len(previews) != 3 {
+		t.Fatalf("got %d previews, want 3", len(previews))
+	}
+	// The linked preview is the oldest but a chirp still shows it
+	if _, exists := previews["https://old.example"]; exists {
+		t.Error("oldest unlinked preview was kept")
+	}
+	for _, url := range []string{"https://linked.example", "https://middle.example", "https://new.example"} {
+		if _, exists := previews[url]; !exists {
+			t.Errorf("%s was evicted", url)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package db
+
+import "time"
+
+// LoginAttempt tracks failed logins for a single key, either an email
+// address or a client IP.
+type LoginAttempt struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+func (db *DB) GetLoginAttempt(key string) (LoginAttempt, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return LoginAttempt{}, err
+	}
+
+	return dbStructure.LoginAttempts[key], nil
+}
+
+// RecordLoginFailure increments the failure count for each key. Failures older
+// than resetAfter are forgotten first, and lockout decides how long the key is
+// locked for given its new failure count.
+func (db *DB) RecordLoginFailure(keys []string, now time.Time, resetAfter time.Duration, lockout func(key string, failures int) time.Duration) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for _, key := range keys {
+			attempt := dbStructure.LoginAttempts[key]
+			if now.Sub(attempt.LastFailure) > resetAfter {
+				attempt.Failures = 0
+			}
+			attempt.Key = key
+			attempt.Failures++
+			attempt.LastFailure = now
+			if lockFor := lockout(key, attempt.Failures); lockFor > 0 {
+				attempt.LockedUntil = now.Add(lockFor)
+			}
+			dbStructure.LoginAttempts[key] = attempt
+		}
+		return nil
+	})
+}
+
+func (db *DB) ClearLoginAttempts(keys ...string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for _, key := range keys {
+			delete(dbStructure.LoginAttempts, key)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Media is an uploaded file. The bytes live in a blob store under BlobKey;
+// ChirpID is 0 until the media is attached to a chirp.
+type Media struct {
+	ID                   string    `json:"id"`
+	OwnerID              int       `json:"owner_id"`
+	ChirpID              int       `json:"chirp_id"`
+	ContentType          string    `json:"content_type"`
+	Size                 int       `json:"size"`
+	Width                int       `json:"width"`
+	Height               int       `json:"height"`
+	BlobKey              string    `json:"blob_key"`
+	ThumbnailKey         string    `json:"thumbnail_key"`
+	ThumbnailContentType string    `json:"thumbnail_content_type"`
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+// CreateMedia records media once store has written its blobs and set their
+// keys. store runs under the write lock, so DeleteBlobs can't remove a blob
+// the new media shares before the media refers to it.
+func (db *DB) CreateMedia(media Media, store func(media *Media) error) (Media, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		err := store(&media)
+		if err != nil {
+			return err
+		}
+		dbStructure.Media[media.ID] = media
+		return nil
+	})
+	if err != nil {
+		return Media{}, err
+	}
+	return media, nil
+}
+
+// DeleteBlobs calls remove for each of keys that no media refers to any more.
+// It holds the write lock throughout, so an upload of the same bytes can't
+// start referring to a blob as it is removed.
+func (db *DB) DeleteBlobs(keys []string, remove func(key string) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStructure, err := db.readFile()
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, key := range dbStructure.unreferencedBlobs(slices.Clone(keys)) {
+		err = remove(key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("blob %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MediaUse is where a piece of media is shown. Chirp is the chirp it is
+// attached to, if that chirp still exists.
+type MediaUse struct {
+	Chirp    Chirp
+	HasChirp bool
+	Avatar   bool
+}
+
+// GetMediaUse returns the media along with the chirp it is attached to and
+// whether anyone has it as their avatar.
+func (db *DB) GetMediaUse(id string) (Media, MediaUse, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Media{}, MediaUse{}, err
+	}
+
+	media, exists := dbStructure.Media[id]
+	if !exists {
+		return Media{}, MediaUse{}, errors.New("could not find media")
+	}
+
+	use := MediaUse{}
+	if media.ChirpID != 0 {
+		use.Chirp, use.HasChirp = dbStructure.Chirps[media.ChirpID]
+	}
+	use.Avatar = dbStructure.isAvatar(id)
+
+	return media, use, nil
+}
+
+func (db *DB) GetMedia(id string) (Media, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Media{}, err
+	}
+
+	media, exists := dbStructure.Media[id]
+	if !exists {
+		return Media{}, errors.New("could not find media")
+	}
+
+	return media, nil
+}
+
+// unreferencedBlobs returns the distinct keys that no media refers to. Blobs
+// are content addressed, so another upload of the same file shares its key.
+func (dbStructure *DBStructure) unreferencedBlobs(keys []string) []string {
+	for _, media := range dbStructure.Media {
+		keys = slices.DeleteFunc(keys, func(key string) bool {
+			return key == media.BlobKey || key == media.ThumbnailKey
+		})
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+func (dbStructure *DBStructure) isAvatar(mediaId string) bool {
+	for _, user := range dbStructure.Users {
+		if user.AvatarMediaID == mediaId {
+			return true
+		}
+	}
+	return false
+}
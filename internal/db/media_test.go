@@ -0,0 +1,116 @@
+package db
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDeleteChirpDeletesItsMedia(t *testing.T) {
+	database, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	author, err := database.CreateUser("author@example.com", "hash", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+	other, err := database.CreateUser("other@example.com", "hash", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+
+	uploads := []Media{
+		{ID: "photo", OwnerID: author.ID, BlobKey: "photo-blob", ThumbnailKey: "photo-thumb"},
+		{ID: "avatar", OwnerID: author.ID, BlobKey: "avatar-blob", ThumbnailKey: "avatar-thumb"},
+		{ID: "shared", OwnerID: author.ID, BlobKey: "shared-blob", ThumbnailKey: "shared-thumb"},
+		// Another user uploaded the same bytes as "shared"
+		{ID: "copy", OwnerID: other.ID, BlobKey: "shared-blob", ThumbnailKey: "shared-thumb"},
+	}
+	for _, media := range uploads {
+		_, err = database.CreateMedia(media, func(*Media) error { return nil })
+		if err != nil {
+			t.Fatalf("CreateMedia: %s", err)
+		}
+	}
+	chirp, err := database.CreateChirp(Chirp{
+		AuthorID: author.ID,
+		Body:     "with pictures",
+		MediaIDs: []string{"photo", "avatar", "shared"},
+	})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+	_, err = database.UpdateProfile(author.ID, Profile{Handle: author.Handle, AvatarMediaID: "avatar"})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %s", err)
+	}
+
+	blobKeys, err := database.DeleteChirp(chirp.ID)
+	if err != nil {
+		t.Fatalf("DeleteChirp: %s", err)
+	}
+	if want := []string{"photo-blob", "photo-thumb"}; !slices.Equal(blobKeys, want) {
+		t.Errorf("unreferenced blobs = %v, want %v", blobKeys, want)
+	}
+
+	for _, id := range []string{"photo", "shared"} {
+		_, err = database.GetMedia(id)
+		if err == nil {
+			t.Errorf("media %s of the deleted chirp was kept", id)
+		}
+	}
+	avatar, err := database.GetMedia("avatar")
+	if err != nil {
+		t.Fatalf("the author's avatar was deleted with the chirp: %s", err)
+	}
+	if avatar.ChirpID != 0 {
+		t.Errorf("avatar is still attached to chirp %d", avatar.ChirpID)
+	}
+	_, err = database.GetMedia("copy")
+	if err != nil {
+		t.Errorf("another user's media was deleted: %s", err)
+	}
+}
+
+func TestDeleteBlobsSkipsReferencedKeys(t *testing.T) {
+	database, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	user, err := database.CreateUser("uploader@example.com", "hash", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+
+	// The same bytes were uploaded again after the keys were found to be
+	// unreferenced
+	_, err = database.CreateMedia(Media{ID: "again", OwnerID: user.ID}, func(media *Media) error {
+		media.BlobKey = "photo-blob"
+		media.ThumbnailKey = "photo-thumb"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("CreateMedia: %s", err)
+	}
+
+	removed := []string{}
+	err = database.DeleteBlobs([]string{"gone-blob", "photo-blob", "photo-thumb"}, func(key string) error {
+		removed = append(removed, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DeleteBlobs: %s", err)
+	}
+	if want := []string{"gone-blob"}; !slices.Equal(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+
+	media, err := database.GetMedia("again")
+	if err != nil {
+		t.Fatalf("GetMedia: %s", err)
+	}
+	if media.BlobKey != "photo-blob" || media.ThumbnailKey != "photo-thumb" {
+		t.Errorf("stored media has keys %q and %q, want the ones store set", media.BlobKey, media.ThumbnailKey)
+	}
+}
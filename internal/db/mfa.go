@@ -0,0 +1,125 @@
+package db
+
+import (
+	"errors"
+	"slices"
+)
+
+var (
+	ErrTOTPCodeReused      = errors.New("totp code has already been used")
+	ErrMFAChallengeUsed    = errors.New("mfa challenge is invalid or already used")
+	ErrRecoveryCodeInvalid = errors.New("recovery code is invalid or already used")
+)
+
+// SetMFAChallenge stores the ID of the most recently issued MFA challenge
+// token. Only that token can complete the login, and only once.
+func (db *DB) SetMFAChallenge(userId int, challengeId string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		user.MFAChallengeID = challengeId
+		dbStructure.Users[userId] = user
+		return nil
+	})
+}
+
+// SetPendingTOTP stores a secret that only takes effect once EnableTOTP
+// confirms the user can produce codes from it.
+func (db *DB) SetPendingTOTP(userId int, secret string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		if user.TOTPEnabled {
+			return errors.New("totp is already enabled")
+		}
+		user.TOTPSecret = secret
+		dbStructure.Users[userId] = user
+		return nil
+	})
+}
+
+// EnableTOTP turns on two-factor login and replaces the recovery codes, which
+// are stored hashed.
+func (db *DB) EnableTOTP(userId int, counter int64, recoveryCodeHashes []string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		user.TOTPEnabled = true
+		user.TOTPLastCounter = counter
+		user.RecoveryCodes = recoveryCodeHashes
+		dbStructure.Users[userId] = user
+		return nil
+	})
+}
+
+func (db *DB) DisableTOTP(userId int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPLastCounter = 0
+		user.RecoveryCodes = nil
+		dbStructure.Users[userId] = user
+		return nil
+	})
+}
+
+// UseTOTPCounter records the time step of an accepted code so the same code
+// can't be replayed within its validity window.
+func (db *DB) UseTOTPCounter(userId int, counter int64) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		if counter <= user.TOTPLastCounter {
+			return ErrTOTPCodeReused
+		}
+		user.TOTPLastCounter = counter
+		dbStructure.Users[userId] = user
+		return nil
+	})
+}
+
+// CompleteMFAChallenge finishes an MFA login. The challenge is consumed in
+// the same update as the TOTP counter or, when recoveryCodeHash is set, the
+// recovery code, and nothing is used up unless both are valid. That way each
+// challenge completes at most one login and a replayed one can't waste a
+// code.
+func (db *DB) CompleteMFAChallenge(userId int, challengeId string, counter int64, recoveryCodeHash string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		if user.MFAChallengeID == "" || user.MFAChallengeID != challengeId {
+			return ErrMFAChallengeUsed
+		}
+
+		if recoveryCodeHash != "" {
+			i := slices.Index(user.RecoveryCodes, recoveryCodeHash)
+			if i < 0 {
+				return ErrRecoveryCodeInvalid
+			}
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+		} else {
+			if counter <= user.TOTPLastCounter {
+				return ErrTOTPCodeReused
+			}
+			user.TOTPLastCounter = counter
+		}
+
+		user.MFAChallengeID = ""
+		dbStructure.Users[userId] = user
+		return nil
+	})
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCompleteMFAChallenge(t *testing.T) {
+	database, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	user, err := database.CreateUser("mfa@example.com", "hash", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+	err = database.EnableTOTP(user.ID, 100, []string{"code-a", "code-b"})
+	if err != nil {
+		t.Fatalf("EnableTOTP: %s", err)
+	}
+	recoveryCodes := func() []string {
+		t.Helper()
+		stored, err := database.GetUserByID(user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %s", err)
+		}
+		return stored.RecoveryCodes
+	}
+
+	err = database.SetMFAChallenge(user.ID, "challenge-1")
+	if err != nil {
+		t.Fatalf("SetMFAChallenge: %s", err)
+	}
+
+	// A wrong code leaves the challenge usable
+	err = database.CompleteMFAChallenge(user.ID, "challenge-1", 0, "wrong")
+	if !errors.Is(err, ErrRecoveryCodeInvalid) {
+		t.Fatalf("wrong recovery code = %v, want %v", err, ErrRecoveryCodeInvalid)
+	}
+	err = database.CompleteMFAChallenge(user.ID, "challenge-1", 100, "")
+	if !errors.Is(err, ErrTOTPCodeReused) {
+		t.Fatalf("reused TOTP counter = %v, want %v", err, ErrTOTPCodeReused)
+	}
+	err = database.CompleteMFAChallenge(user.ID, "challenge-1", 0, "code-a")
+	if err != nil {
+		t.Fatalf("CompleteMFAChallenge: %s", err)
+	}
+	if codes := recoveryCodes(); !slices.Equal(codes, []string{"code-b"}) {
+		t.Errorf("recovery codes after use = %v, want [code-b]", codes)
+	}
+
+	// Replaying the used challenge must not burn another code
+	err = database.CompleteMFAChallenge(user.ID, "challenge-1", 0, "code-b")
+	if !errors.Is(err, ErrMFAChallengeUsed) {
+		t.Fatalf("replayed challenge = %v, want %v", err, ErrMFAChallengeUsed)
+	}
+	if codes := recoveryCodes(); !slices.Equal(codes, []string{"code-b"}) {
+		t.Errorf("replayed challenge used a code, left %v", codes)
+	}
+
+	err = database.SetMFAChallenge(user.ID, "challenge-2")
+	if err != nil {
+		t.Fatalf("SetMFAChallenge: %s", err)
+	}
+	err = database.CompleteMFAChallenge(user.ID, "challenge-2", 101, "")
+	if err != nil {
+		t.Fatalf("CompleteMFAChallenge with TOTP: %s", err)
+	}
+	stored, err := database.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %s", err)
+	}
+	if stored.TOTPLastCounter != 101 || stored.MFAChallengeID != "" {
+		t.Errorf("after TOTP login got counter %d and challenge %q", stored.TOTPLastCounter, stored.MFAChallengeID)
+	}
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	NotificationReply   = "reply"
+	NotificationMention = "mention"
+	NotificationFollow  = "follow"
+
+	NotificationFollowRequest  = "follow_request"
+	NotificationFollowApproved = "follow_approved"
+)
+
+type Notification struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Type      string    `json:"type"`
+	ActorID   int       `json:"actor_id"`
+	ChirpID   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+// NotificationPrefs controls which notification types a user receives.
+// Users without stored preferences get DefaultNotificationPrefs.
+type NotificationPrefs struct {
+	Replies  bool `json:"replies"`
+	Mentions bool `json:"mentions"`
+	Follows  bool `json:"follows"`
+}
+
+func DefaultNotificationPrefs() NotificationPrefs {
+	return NotificationPrefs{
+		Replies:  true,
+		Mentions: true,
+		Follows:  true,
+	}
+}
+
+func (prefs NotificationPrefs) Allows(notificationType string) bool {
+	switch notificationType {
+	case NotificationReply:
+		return prefs.Replies
+	case NotificationMention:
+		return prefs.Mentions
+	case NotificationFollow, NotificationFollowRequest, NotificationFollowApproved:
+		return prefs.Follows
+	}
+	return false
+}
+
+// CreateNotifications stores the notifications their recipients have opted
+// into, skipping any a user would receive about their own activity.
+func (db *DB) CreateNotifications(notifications []Notification) ([]Notification, error) {
+	created := []Notification{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		id := 1
+		for existingId := range dbStructure.Notifications {
+			if existingId >= id {
+				id = existingId + 1
+			}
+		}
+
+		for _, notification := range notifications {
+			if notification.UserID == notification.ActorID {
+				continue
+			}
+			if !dbStructure.notificationPrefs(notification.UserID).Allows(notification.Type) {
+				continue
+			}
+
+			notification.ID = id
+			notification.CreatedAt = time.Now().UTC()
+			dbStructure.Notifications[id] = notification
+			created = append(created, notification)
+			id++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetNotifications returns a user's notifications, newest first.
+func (db *DB) GetNotifications(userId int) ([]Notification, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := []Notification{}
+	for _, notification := range dbStructure.Notifications {
+		if notification.UserID == userId {
+			notifications = append(notifications, notification)
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].ID > notifications[j].ID
+	})
+
+	return notifications, nil
+}
+
+// MarkNotificationsRead marks the given notifications, or all of the user's
+// notifications when ids is nil, as read. It returns how many changed.
+func (db *DB) MarkNotificationsRead(userId int, ids []int) (int, error) {
+	marked := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		wanted := map[int]bool{}
+		for _, id := range ids {
+			wanted[id] = true
+		}
+
+		now := time.Now().UTC()
+		for id, notification := range dbStructure.Notifications {
+			if notification.UserID != userId || !notification.ReadAt.IsZero() {
+				continue
+			}
+			if ids != nil && !wanted[id] {
+				continue
+			}
+			notification.ReadAt = now
+			dbStructure.Notifications[id] = notification
+			marked++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return marked, nil
+}
+
+func (db *DB) GetNotificationPrefs(userId int) (NotificationPrefs, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return NotificationPrefs{}, err
+	}
+
+	return dbStructure.notificationPrefs(userId), nil
+}
+
+func (db *DB) SetNotificationPrefs(userId int, prefs NotificationPrefs) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		dbStructure.NotificationPrefs[userId] = prefs
+		return nil
+	})
+}
+
+func (dbStructure *DBStructure) notificationPrefs(userId int) NotificationPrefs {
+	prefs, exists := dbStructure.NotificationPrefs[userId]
+	if !exists {
+		return DefaultNotificationPrefs()
+	}
+	return prefs
+}
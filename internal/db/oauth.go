@@ -0,0 +1,177 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidAuthorizationCode = errors.New("authorization code is invalid or expired")
+
+// OAuthClient is a third-party application registered by a Chirpy user.
+// Public clients have no secret and rely on PKCE alone.
+type OAuthClient struct {
+	ID           string    `json:"id"`
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	Name         string    `json:"name"`
+	OwnerID      int       `json:"owner_id"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// OAuthConsent records the scopes a user has approved for a client.
+type OAuthConsent struct {
+	UserID    int       `json:"user_id"`
+	ClientID  string    `json:"client_id"`
+	Scopes    []string  `json:"scopes"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// AuthorizationCode is keyed by the hash of the code handed to the client.
+type AuthorizationCode struct {
+	CodeHash      string    `json:"code_hash"`
+	ClientID      string    `json:"client_id"`
+	UserID        int       `json:"user_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scopes        []string  `json:"scopes"`
+	CodeChallenge string    `json:"code_challenge"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+func consentKey(userId int, clientId string) string {
+	return fmt.Sprintf("%d:%s", userId, clientId)
+}
+
+func (db *DB) CreateOAuthClient(client OAuthClient) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, exists := dbStructure.OAuthClients[client.ID]; exists {
+			return errors.New("client id already exists")
+		}
+		dbStructure.OAuthClients[client.ID] = client
+		return nil
+	})
+}
+
+func (db *DB) GetOAuthClient(clientId string) (OAuthClient, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return OAuthClient{}, err
+	}
+
+	client, exists := dbStructure.OAuthClients[clientId]
+	if !exists {
+		return OAuthClient{}, errors.New("could not find client")
+	}
+
+	return client, nil
+}
+
+func (db *DB) GetOAuthClientsByOwner(ownerId int) ([]OAuthClient, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	clients := []OAuthClient{}
+	for _, client := range dbStructure.OAuthClients {
+		if client.OwnerID == ownerId {
+			clients = append(clients, client)
+		}
+	}
+
+	return clients, nil
+}
+
+func (db *DB) GetOAuthConsent(userId int, clientId string) (OAuthConsent, bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return OAuthConsent{}, false, err
+	}
+
+	consent, exists := dbStructure.OAuthConsents[consentKey(userId, clientId)]
+	return consent, exists, nil
+}
+
+func (db *DB) GetOAuthConsentsByUser(userId int) ([]OAuthConsent, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	consents := []OAuthConsent{}
+	for _, consent := range dbStructure.OAuthConsents {
+		if consent.UserID == userId {
+			consents = append(consents, consent)
+		}
+	}
+
+	return consents, nil
+}
+
+func (db *DB) SaveOAuthConsent(consent OAuthConsent) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		dbStructure.OAuthConsents[consentKey(consent.UserID, consent.ClientID)] = consent
+		return nil
+	})
+}
+
+// DeleteOAuthConsent withdraws consent and revokes every access token the
+// client holds for the user.
+func (db *DB) DeleteOAuthConsent(userId int, clientId string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		key := consentKey(userId, clientId)
+		if _, exists := dbStructure.OAuthConsents[key]; !exists {
+			return errors.New("could not find consent")
+		}
+		delete(dbStructure.OAuthConsents, key)
+
+		for id, token := range dbStructure.AccessTokens {
+			if token.UserID == userId && token.ClientID == clientId {
+				dbStructure.RevokedTokens[id] = RevokedToken{
+					ID:        token.ID,
+					UserID:    token.UserID,
+					ExpiresAt: token.ExpiresAt,
+				}
+				delete(dbStructure.AccessTokens, id)
+			}
+		}
+		return nil
+	})
+}
+
+func (db *DB) CreateAuthorizationCode(code AuthorizationCode) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for hash, existing := range dbStructure.AuthorizationCodes {
+			if !existing.ExpiresAt.After(now) {
+				delete(dbStructure.AuthorizationCodes, hash)
+			}
+		}
+		dbStructure.AuthorizationCodes[code.CodeHash] = code
+		return nil
+	})
+}
+
+// ConsumeAuthorizationCode removes the code so it can only be exchanged once.
+func (db *DB) ConsumeAuthorizationCode(codeHash string) (AuthorizationCode, error) {
+	code := AuthorizationCode{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		existing, exists := dbStructure.AuthorizationCodes[codeHash]
+		if !exists {
+			return ErrInvalidAuthorizationCode
+		}
+		code = existing
+		delete(dbStructure.AuthorizationCodes, codeHash)
+		return nil
+	})
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+
+	if !code.ExpiresAt.After(time.Now().UTC()) {
+		return AuthorizationCode{}, ErrInvalidAuthorizationCode
+	}
+
+	return code, nil
+}
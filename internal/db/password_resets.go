@@ -0,0 +1,69 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidResetToken = errors.New("reset token is invalid or expired")
+
+// PasswordReset is keyed by the hash of the emailed token, never the token.
+type PasswordReset struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreatePasswordReset replaces any outstanding reset tokens for the user.
+func (db *DB) CreatePasswordReset(userId int, tokenHash string, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for hash, reset := range dbStructure.PasswordResets {
+			if reset.UserID == userId || !reset.ExpiresAt.After(now) {
+				delete(dbStructure.PasswordResets, hash)
+			}
+		}
+
+		dbStructure.PasswordResets[tokenHash] = PasswordReset{
+			TokenHash: tokenHash,
+			UserID:    userId,
+			ExpiresAt: expiresAt,
+		}
+		return nil
+	})
+}
+
+// ResetPassword consumes the reset token and stores the new password hash.
+func (db *DB) ResetPassword(tokenHash string, hashedPassword string) (int, error) {
+	userId := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		reset, exists := dbStructure.PasswordResets[tokenHash]
+		if !exists {
+			return ErrInvalidResetToken
+		}
+		delete(dbStructure.PasswordResets, tokenHash)
+
+		// An expired token is still removed, so it is saved without
+		// touching the password
+		if !reset.ExpiresAt.After(time.Now().UTC()) {
+			return nil
+		}
+
+		user, exists := dbStructure.Users[reset.UserID]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		user.Password = []byte(hashedPassword)
+		dbStructure.Users[reset.UserID] = user
+		userId = reset.UserID
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if userId == 0 {
+		return 0, ErrInvalidResetToken
+	}
+
+	return userId, nil
+}
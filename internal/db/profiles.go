@@ -0,0 +1,136 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrHandleTaken = errors.New("handle is already taken")
+
+// Profile is the public part of a user that they can edit.
+type Profile struct {
+	Handle        string
+	DisplayName   string
+	Bio           string
+	AvatarMediaID string
+}
+
+// ProfileStats counts what a profile page shows alongside the profile.
+type ProfileStats struct {
+	Chirps    int
+	Followers int
+	Following int
+}
+
+// GetUserByHandle finds a user by handle, ignoring case.
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	for _, user := range dbStructure.Users {
+		if user.Handle != "" && strings.EqualFold(user.Handle, handle) {
+			return user, nil
+		}
+	}
+
+	return User{}, errors.New("could not find user")
+}
+
+// GetUsers returns the users with the given ids that exist, keyed by id.
+func (db *DB) GetUsers(ids []int) (map[int]User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[int]User{}
+	for _, id := range ids {
+		user, exists := dbStructure.Users[id]
+		if exists {
+			users[id] = user
+		}
+	}
+
+	return users, nil
+}
+
+// UpdateProfile replaces a user's profile. It returns ErrHandleTaken if
+// another user has the handle, ignoring case.
+func (db *DB) UpdateProfile(userId int, profile Profile) (User, error) {
+	user := User{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		existing, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		if dbStructure.handleTaken(profile.Handle, userId) {
+			return ErrHandleTaken
+		}
+
+		user = existing
+		user.Handle = profile.Handle
+		user.DisplayName = profile.DisplayName
+		user.Bio = profile.Bio
+		user.AvatarMediaID = profile.AvatarMediaID
+		dbStructure.Users[userId] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// GetProfileStats counts a user's published chirps and approved follows.
+func (db *DB) GetProfileStats(userId int) (ProfileStats, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ProfileStats{}, err
+	}
+
+	stats := ProfileStats{}
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID == userId && chirp.Published() {
+			stats.Chirps++
+		}
+	}
+	for _, follow := range dbStructure.Follows {
+		if !follow.Approved() {
+			continue
+		}
+		if follow.FolloweeID == userId {
+			stats.Followers++
+		}
+		if follow.FollowerID == userId {
+			stats.Following++
+		}
+	}
+
+	return stats, nil
+}
+
+// handleTaken reports whether a user other than exceptId has handle. An
+// empty handle is never taken.
+func (dbStructure *DBStructure) handleTaken(handle string, exceptId int) bool {
+	if handle == "" {
+		return false
+	}
+	for _, user := range dbStructure.Users {
+		if user.ID != exceptId && strings.EqualFold(user.Handle, handle) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultHandle picks a free handle for a user who didn't choose one.
+func (dbStructure *DBStructure) defaultHandle(userId int) string {
+	handle := "user" + strconv.Itoa(userId)
+	for suffix := 2; dbStructure.handleTaken(handle, userId); suffix++ {
+		handle = "user" + strconv.Itoa(userId) + "_" + strconv.Itoa(suffix)
+	}
+	return handle
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrChirpPublished = errors.New("chirp is already published")
+
+// PublishChirp publishes a draft or scheduled chirp immediately. It returns
+// ErrChirpPublished if the chirp was already published, including by the
+// scheduler, so listeners only hear about each chirp once.
+func (db *DB) PublishChirp(chirpId int, now time.Time) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		existing, exists := dbStructure.Chirps[chirpId]
+		if !exists {
+			return errors.New("could not find chirp")
+		}
+		if existing.Published() {
+			return ErrChirpPublished
+		}
+		chirp = publish(existing, now)
+		dbStructure.Chirps[chirpId] = chirp
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	db.notifyChirp(ChirpCreated, chirp)
+
+	return chirp, nil
+}
+
+// PublishDueChirps publishes every scheduled chirp whose publish time has
+// passed. Because the schedule lives in the database, chirps that came due
+// while the server was down are published on the first run after a restart.
+func (db *DB) PublishDueChirps(now time.Time) ([]Chirp, error) {
+	published := []Chirp{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		due := []Chirp{}
+		for _, chirp := range dbStructure.Chirps {
+			if chirp.Status == ChirpStatusScheduled && !chirp.PublishAt.After(now) {
+				due = append(due, chirp)
+			}
+		}
+		// Publish in the order the chirps were scheduled for
+		sort.Slice(due, func(i, j int) bool {
+			if due[i].PublishAt.Equal(due[j].PublishAt) {
+				return due[i].ID < due[j].ID
+			}
+			return due[i].PublishAt.Before(due[j].PublishAt)
+		})
+		for _, chirp := range due {
+			chirp = publish(chirp, now)
+			dbStructure.Chirps[chirp.ID] = chirp
+			published = append(published, chirp)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chirp := range published {
+		db.notifyChirp(ChirpCreated, chirp)
+	}
+
+	return published, nil
+}
+
+// GetUnpublishedChirps returns an author's drafts and scheduled chirps.
+func (db *DB) GetUnpublishedChirps(authorId int) ([]Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	chirps := []Chirp{}
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID == authorId && !chirp.Published() {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	return chirps, nil
+}
+
+// publish marks the chirp published. CreatedAt becomes the publish time so
+// the chirp sorts with those published around it rather than when it was
+// drafted.
+func publish(chirp Chirp, now time.Time) Chirp {
+	chirp.Status = ChirpStatusPublished
+	chirp.CreatedAt = now.UTC()
+	chirp.PublishAt = time.Time{}
+	return chirp
+}
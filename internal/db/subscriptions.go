@@ -0,0 +1,70 @@
+package db
+
+import "time"
+
+// legacyPlan and legacyPeriod describe the subscription given to users who
+// were upgraded before subscriptions were tracked. They keep Chirpy Red for
+// one more period, after which a Polka renewal is needed like anyone else.
+const (
+	legacyPlan   = "chirpy_red"
+	legacyPeriod = 30 * 24 * time.Hour
+)
+
+const (
+	SubscriptionActive    = "active"
+	SubscriptionCancelled = "cancelled"
+	SubscriptionPastDue   = "past_due"
+	SubscriptionInactive  = "inactive"
+)
+
+// Subscription mirrors the user's Polka subscription. A cancelled or past due
+// subscription keeps its benefits until CurrentPeriodEnd.
+type Subscription struct {
+	Plan             string    `json:"plan"`
+	Status           string    `json:"status"`
+	CurrentPeriodEnd time.Time `json:"current_period_end"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Entitled reports whether the subscription still grants Chirpy Red at now.
+func (s Subscription) Entitled(now time.Time) bool {
+	switch s.Status {
+	case SubscriptionActive, SubscriptionCancelled, SubscriptionPastDue:
+		return s.CurrentPeriodEnd.After(now)
+	}
+	return false
+}
+
+// withSubscription returns the user on subscription, with IsRed kept in
+// step with it.
+func (user User) withSubscription(subscription Subscription, now time.Time) User {
+	subscription.UpdatedAt = now
+	user.Subscription = subscription
+	user.IsRed = subscription.Entitled(now)
+	return user
+}
+
+// ExpireSubscriptions removes Chirpy Red from users whose paid period has
+// lapsed and returns how many were changed.
+func (db *DB) ExpireSubscriptions(now time.Time) (int, error) {
+	expired := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, user := range dbStructure.Users {
+			// Users who never subscribed have no status
+			status := user.Subscription.Status
+			if status == "" || status == SubscriptionInactive || user.Subscription.Entitled(now) {
+				continue
+			}
+			user.IsRed = false
+			user.Subscription.Status = SubscriptionInactive
+			user.Subscription.UpdatedAt = now
+			dbStructure.Users[id] = user
+			expired++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
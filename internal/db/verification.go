@@ -0,0 +1,43 @@
+package db
+
+import "errors"
+
+var ErrVerificationTokenUsed = errors.New("verification token is invalid or already used")
+
+// SetVerificationToken stores the ID of the most recently issued verification
+// token. Only that token can verify the account, and only once.
+func (db *DB) SetVerificationToken(userId int, tokenId string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		user.VerificationTokenID = tokenId
+		dbStructure.Users[userId] = user
+		return nil
+	})
+}
+
+func (db *DB) VerifyUser(userId int, tokenId string) (User, error) {
+	user := User{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		existing, exists := dbStructure.Users[userId]
+		if !exists {
+			return errors.New("could not find user")
+		}
+		if existing.VerificationTokenID == "" || existing.VerificationTokenID != tokenId {
+			return ErrVerificationTokenUsed
+		}
+
+		user = existing
+		user.IsVerified = true
+		user.VerificationTokenID = ""
+		dbStructure.Users[userId] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrUserNotFound = errors.New("could not find user")
+
+// ProcessedWebhook remembers an incoming webhook event so a repeated delivery
+// of the same event is ignored.
+type ProcessedWebhook struct {
+	EventID     string    `json:"event_id"`
+	Event       string    `json:"event"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// MarkWebhookProcessed records the event and forgets events older than
+// retention, which must outlast the sender's retry schedule.
+func (db *DB) MarkWebhookProcessed(eventId string, event string, retention time.Duration) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		dbStructure.markWebhookProcessed(eventId, event, retention, time.Now().UTC())
+		return nil
+	})
+}
+
+// ProcessSubscriptionEvent applies a subscription webhook event to the user
+// exactly once. Claiming the event id and changing the subscription happen
+// in a single update, so concurrent retries of the same event can't both be
+// applied. applied is false if the event had already been processed. Nothing
+// is recorded if the user doesn't exist, so the event can be retried.
+func (db *DB) ProcessSubscriptionEvent(eventId string, event string, userId int, retention time.Duration, next func(current Subscription, now time.Time) Subscription) (user User, applied bool, err error) {
+	err = db.update(func(dbStructure *DBStructure) error {
+		if _, processed := dbStructure.ProcessedWebhooks[eventId]; processed {
+			user = dbStructure.Users[userId]
+			return nil
+		}
+
+		existing, exists := dbStructure.Users[userId]
+		if !exists {
+			return ErrUserNotFound
+		}
+		now := time.Now().UTC()
+		user = existing.withSubscription(next(existing.Subscription, now), now)
+		dbStructure.Users[userId] = user
+		dbStructure.markWebhookProcessed(eventId, event, retention, now)
+		applied = true
+		return nil
+	})
+	return user, applied, err
+}
+
+func (dbStructure *DBStructure) markWebhookProcessed(eventId string, event string, retention time.Duration, now time.Time) {
+	for id, processed := range dbStructure.ProcessedWebhooks {
+		if now.Sub(processed.ProcessedAt) > retention {
+			delete(dbStructure.ProcessedWebhooks, id)
+		}
+	}
+	dbStructure.ProcessedWebhooks[eventId] = ProcessedWebhook{
+		EventID:     eventId,
+		Event:       event,
+		ProcessedAt: now,
+	}
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookEndpoint is an outbound webhook subscription. Endpoints with an
+// OwnerID of 0 were registered by an admin and receive every event.
+type WebhookEndpoint struct {
+	ID        string    `json:"id"`
+	OwnerID   int       `json:"owner_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one queued event for one endpoint. Payload holds the
+// exact body so every retry sends the same bytes.
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	EndpointID     string          `json:"endpoint_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastAttemptAt  time.Time       `json:"last_attempt_at"`
+	LastStatusCode int             `json:"last_status_code"`
+	LastError      string          `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+func (db *DB) CreateWebhookEndpoint(endpoint WebhookEndpoint) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		dbStructure.WebhookEndpoints[endpoint.ID] = endpoint
+		return nil
+	})
+}
+
+func (db *DB) GetWebhookEndpoint(id string) (WebhookEndpoint, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	endpoint, exists := dbStructure.WebhookEndpoints[id]
+	if !exists {
+		return WebhookEndpoint{}, errors.New("could not find webhook endpoint")
+	}
+
+	return endpoint, nil
+}
+
+func (db *DB) GetWebhookEndpointsByOwner(ownerId int) ([]WebhookEndpoint, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := []WebhookEndpoint{}
+	for _, endpoint := range dbStructure.WebhookEndpoints {
+		if endpoint.OwnerID == ownerId {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	return endpoints, nil
+}
+
+// DeleteWebhookEndpoint removes the endpoint along with its delivery log.
+func (db *DB) DeleteWebhookEndpoint(ownerId int, id string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		endpoint, exists := dbStructure.WebhookEndpoints[id]
+		if !exists || endpoint.OwnerID != ownerId {
+			return errors.New("could not find webhook endpoint")
+		}
+		delete(dbStructure.WebhookEndpoints, id)
+
+		for deliveryId, delivery := range dbStructure.WebhookDeliveries {
+			if delivery.EndpointID == id {
+				delete(dbStructure.WebhookDeliveries, deliveryId)
+			}
+		}
+		return nil
+	})
+}
+
+// EnqueueWebhookDeliveries queues a delivery to every endpoint subscribed to
+// the event that either belongs to subjectUserId or was registered by an
+// admin. Deliveries older than retention are pruned.
+func (db *DB) EnqueueWebhookDeliveries(event string, subjectUserId int, newDelivery func(endpoint WebhookEndpoint) (WebhookDelivery, error), retention time.Duration) (int, error) {
+	queued := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for id, delivery := range dbStructure.WebhookDeliveries {
+			if delivery.Status != DeliveryPending && now.Sub(delivery.CreatedAt) > retention {
+				delete(dbStructure.WebhookDeliveries, id)
+			}
+		}
+
+		for _, endpoint := range dbStructure.WebhookEndpoints {
+			if endpoint.OwnerID != 0 && endpoint.OwnerID != subjectUserId {
+				continue
+			}
+			subscribed := false
+			for _, e := range endpoint.Events {
+				if e == event {
+					subscribed = true
+					break
+				}
+			}
+			if !subscribed {
+				continue
+			}
+
+			delivery, err := newDelivery(endpoint)
+			if err != nil {
+				return err
+			}
+			dbStructure.WebhookDeliveries[delivery.ID] = delivery
+			queued++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return queued, nil
+}
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is due,
+// oldest first.
+func (db *DB) DueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	due := []WebhookDelivery{}
+	for _, delivery := range dbStructure.WebhookDeliveries {
+		if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+func (db *DB) UpdateWebhookDelivery(delivery WebhookDelivery) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		// The endpoint may have been deleted while the delivery was in flight
+		if _, exists := dbStructure.WebhookDeliveries[delivery.ID]; !exists {
+			return nil
+		}
+		dbStructure.WebhookDeliveries[delivery.ID] = delivery
+		return nil
+	})
+}
+
+// GetWebhookDeliveries returns the delivery log for an endpoint, newest first.
+func (db *DB) GetWebhookDeliveries(endpointId string) ([]WebhookDelivery, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range dbStructure.WebhookDeliveries {
+		if delivery.EndpointID == endpointId {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+
+	return deliveries, nil
+}
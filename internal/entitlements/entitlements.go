@@ -0,0 +1,93 @@
+package entitlements
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+const (
+	PlanFree      = "free"
+	PlanChirpyRed = "chirpy_red"
+)
+
+// Entitlements are the limits and features a plan unlocks.
+type Entitlements struct {
+	MaxChirpLength int  `json:"max_chirp_length"`
+	ChirpsPerHour  int  `json:"chirps_per_hour"`
+	EditChirps     bool `json:"edit_chirps"`
+	ScheduledPosts bool `json:"scheduled_posts"`
+}
+
+// Plans maps a plan name to its entitlements.
+type Plans map[string]Entitlements
+
+func Default() Plans {
+	return Plans{
+		PlanFree: {
+			MaxChirpLength: 140,
+			ChirpsPerHour:  30,
+			EditChirps:     false,
+			ScheduledPosts: false,
+		},
+		PlanChirpyRed: {
+			MaxChirpLength: 560,
+			ChirpsPerHour:  300,
+			EditChirps:     true,
+			ScheduledPosts: true,
+		},
+	}
+}
+
+// Load reads plan overrides from a JSON file shaped like Default. Each plan in
+// the file is applied on top of its default, so fields and plans the file
+// leaves out keep their default values. Plans without a default start from
+// zero values.
+func Load(path string) (Plans, error) {
+	plans := Default()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := map[string]json.RawMessage{}
+	err = json.Unmarshal(data, &overrides)
+	if err != nil {
+		return nil, err
+	}
+	for name, override := range overrides {
+		plan := plans[name]
+		err = json.Unmarshal(override, &plan)
+		if err != nil {
+			return nil, err
+		}
+		plans[name] = plan
+	}
+
+	return plans, nil
+}
+
+// For returns the entitlements of the plan the user is currently on. Users
+// whose subscription has lapsed are on the free plan.
+func (p Plans) For(user db.User) Entitlements {
+	plan := PlanFree
+	if user.IsRed {
+		plan = user.Subscription.Plan
+		if user.Subscription.Status != "" && !user.Subscription.Entitled(time.Now().UTC()) {
+			plan = PlanFree
+		}
+	}
+
+	entitlements, exists := p[plan]
+	if !exists && user.IsRed {
+		entitlements, exists = p[PlanChirpyRed]
+	}
+	if !exists {
+		entitlements = p[PlanFree]
+	}
+
+	return entitlements
+}
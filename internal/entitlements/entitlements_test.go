@@ -0,0 +1,103 @@
+package entitlements
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+func writePlans(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "plans.json")
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	return path
+}
+
+func TestLoadAppliesOverridesToDefaults(t *testing.T) {
+	path := writePlans(t, `{
+		"free": {"chirps_per_hour": 5},
+		"enterprise": {"max_chirp_length": 2000, "edit_chirps": true}
+	}`)
+
+	plans, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	defaults := Default()
+
+	free := defaults[PlanFree]
+	free.ChirpsPerHour = 5
+	if plans[PlanFree] != free {
+		t.Errorf("free = %+v, want the default with chirps_per_hour 5: %+v", plans[PlanFree], free)
+	}
+	if plans[PlanChirpyRed] != defaults[PlanChirpyRed] {
+		t.Errorf("chirpy_red = %+v, want the untouched default %+v", plans[PlanChirpyRed], defaults[PlanChirpyRed])
+	}
+	// A plan with no default starts from zero values
+	want := Entitlements{MaxChirpLength: 2000, EditChirps: true}
+	if plans["enterprise"] != want {
+		t.Errorf("enterprise = %+v, want %+v", plans["enterprise"], want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	if err == nil {
+		t.Error("Load of a missing file succeeded")
+	}
+	_, err = Load(writePlans(t, `{"free": {"chirps_per_hour": "lots"}}`))
+	if err == nil {
+		t.Error("Load with a malformed plan succeeded")
+	}
+	_, err = Load(writePlans(t, `[]`))
+	if err == nil {
+		t.Error("Load of a non-object succeeded")
+	}
+}
+
+func TestFor(t *testing.T) {
+	plans := Default()
+	plans["enterprise"] = Entitlements{MaxChirpLength: 2000}
+	now := time.Now().UTC()
+	subscribed := func(plan string, status string, periodEnd time.Time) db.User {
+		return db.User{
+			IsRed: true,
+			Subscription: db.Subscription{
+				Plan:             plan,
+				Status:           status,
+				CurrentPeriodEnd: periodEnd,
+			},
+		}
+	}
+
+	tests := []struct {
+		name string
+		user db.User
+		want Entitlements
+	}{
+		{"free user", db.User{}, plans[PlanFree]},
+		{"active subscription", subscribed(PlanChirpyRed, db.SubscriptionActive, now.Add(time.Hour)), plans[PlanChirpyRed]},
+		{"custom plan", subscribed("enterprise", db.SubscriptionActive, now.Add(time.Hour)), plans["enterprise"]},
+		{"unknown plan", subscribed("retired", db.SubscriptionActive, now.Add(time.Hour)), plans[PlanChirpyRed]},
+		{"cancelled but paid up", subscribed(PlanChirpyRed, db.SubscriptionCancelled, now.Add(time.Hour)), plans[PlanChirpyRed]},
+		{"lapsed subscription", subscribed(PlanChirpyRed, db.SubscriptionActive, now.Add(-time.Hour)), plans[PlanFree]},
+		{"inactive subscription", subscribed(PlanChirpyRed, db.SubscriptionInactive, now.Add(time.Hour)), plans[PlanFree]},
+		{"untracked Chirpy Red", subscribed("", "", time.Time{}), plans[PlanChirpyRed]},
+		{"plan without Chirpy Red", db.User{Subscription: db.Subscription{Plan: "enterprise"}}, plans[PlanFree]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := plans.For(tt.user)
+			if got != tt.want {
+				t.Errorf("For = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
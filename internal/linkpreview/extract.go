@@ -0,0 +1,41 @@
+package linkpreview
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MaxURLsPerChirp caps how many links in one chirp get previews.
+const MaxURLsPerChirp = 3
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// ExtractURLs returns the distinct http(s) URLs in body, in order of
+// appearance. Trailing punctuation is treated as part of the sentence rather
+// than the link.
+func ExtractURLs(body string) []string {
+	urls := []string{}
+	seen := map[string]bool{}
+	for _, match := range urlPattern.FindAllString(body, -1) {
+		match = strings.TrimRight(match, ".,;:!?'")
+		if strings.HasSuffix(match, ")") && !strings.Contains(match, "(") {
+			match = strings.TrimSuffix(match, ")")
+		}
+
+		parsed, err := url.Parse(match)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+		if seen[match] {
+			continue
+		}
+		seen[match] = true
+		urls = append(urls, match)
+		if len(urls) == MaxURLsPerChirp {
+			break
+		}
+	}
+
+	return urls
+}
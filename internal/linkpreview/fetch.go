@@ -0,0 +1,246 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	ErrBlockedAddress = errors.New("address is not publicly routable")
+	ErrNotHTML        = errors.New("response is not an HTML document")
+)
+
+// Preview is the OpenGraph or Twitter card metadata of a page.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	Image       string
+	SiteName    string
+}
+
+// Fetcher retrieves page metadata. Every connection is checked against
+// IsBlocked after DNS resolution, so neither hostnames that resolve to
+// private addresses nor redirects to them can reach internal services.
+type Fetcher struct {
+	Timeout      time.Duration
+	MaxBytes     int64
+	MaxRedirects int
+	UserAgent    string
+	IsBlocked    func(ip net.IP) bool
+}
+
+func NewFetcher() *Fetcher {
+	return &Fetcher{
+		Timeout:      5 * time.Second,
+		MaxBytes:     512 * 1024,
+		MaxRedirects: 3,
+		UserAgent:    "Chirpy-LinkPreview/1.0",
+		IsBlocked:    IsPrivateIP,
+	}
+}
+
+// IsPrivateIP reports whether ip is loopback, private, link-local (which
+// includes cloud metadata services), multicast or otherwise not a public
+// unicast address.
+func IsPrivateIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		// Carrier-grade NAT, 100.64.0.0/10
+		if ip[0] == 100 && ip[1]&0xc0 == 64 {
+			return true
+		}
+		// "This network", 0.0.0.0/8
+		if ip[0] == 0 {
+			return true
+		}
+	}
+
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified()
+}
+
+func (fetcher *Fetcher) client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: fetcher.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || fetcher.IsBlocked(ip) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: fetcher.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   fetcher.Timeout,
+			ResponseHeaderTimeout: fetcher.Timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > fetcher.MaxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("redirect to unsupported scheme")
+			}
+			return nil
+		},
+	}
+}
+
+func (fetcher *Fetcher) Fetch(ctx context.Context, rawURL string) (Preview, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return Preview{}, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return Preview{}, errors.New("unsupported scheme")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return Preview{}, err
+	}
+	req.Header.Set("User-Agent", fetcher.UserAgent)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := fetcher.client().Do(req)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Preview{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return Preview{}, ErrNotHTML
+	}
+
+	preview := parseMetadata(io.LimitReader(resp.Body, fetcher.MaxBytes))
+	preview.URL = rawURL
+	if preview.Image != "" {
+		preview.Image = resolveURL(resp.Request.URL, preview.Image)
+	}
+
+	return preview, nil
+}
+
+// parseMetadata reads the document head. OpenGraph properties win over
+// Twitter card ones, which win over <title> and the plain description.
+func parseMetadata(r io.Reader) Preview {
+	meta := map[string]string{}
+	title := ""
+	inTitle := false
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return buildPreview(meta, title)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				key, content := "", ""
+				for _, attr := range token.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				if key != "" && content != "" {
+					if _, exists := meta[key]; !exists {
+						meta[key] = content
+					}
+				}
+			case "body":
+				return buildPreview(meta, title)
+			}
+		case html.TextToken:
+			if inTitle {
+				title = strings.TrimSpace(string(tokenizer.Text()))
+				inTitle = false
+			}
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if token.Data == "head" {
+				return buildPreview(meta, title)
+			}
+		}
+	}
+}
+
+func buildPreview(meta map[string]string, pageTitle string) Preview {
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if meta[key] != "" {
+				return truncate(meta[key], 500)
+			}
+		}
+		return ""
+	}
+
+	title := first("og:title", "twitter:title")
+	if title == "" {
+		title = truncate(pageTitle, 500)
+	}
+
+	return Preview{
+		Title:       title,
+		Description: first("og:description", "twitter:description", "description"),
+		Image:       first("og:image", "og:image:url", "twitter:image", "twitter:image:src"),
+		SiteName:    first("og:site_name"),
+	}
+}
+
+func resolveURL(base *url.URL, ref string) string {
+	parsed, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	resolved := base.ResolveReference(parsed)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return ""
+	}
+	return resolved.String()
+}
+
+func truncate(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit])
+}
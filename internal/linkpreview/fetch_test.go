@@ -0,0 +1,180 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFetcher allows loopback so it can reach httptest servers.
+func testFetcher() *Fetcher {
+	fetcher := NewFetcher()
+	fetcher.IsBlocked = func(ip net.IP) bool { return false }
+	return fetcher
+}
+
+func serveHTML(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestFetchOpenGraph(t *testing.T) {
+	server := serveHTML(`<!doctype html><html><head>
+		<title>Page title</title>
+		<meta property="og:title" content="OG title">
+		<meta property="og:description" content="OG description">
+		<meta property="og:image" content="/images/card.png">
+		<meta property="og:site_name" content="Example">
+		<meta name="twitter:title" content="Twitter title">
+		<meta name="description" content="Plain description">
+	</head><body><meta property="og:title" content="Too late"></body></html>`)
+	defer server.Close()
+
+	preview, err := testFetcher().Fetch(context.Background(), server.URL+"/post")
+	if err != nil {
+		t.Fatalf("Fetch: %s", err)
+	}
+	want := Preview{
+		URL:         server.URL + "/post",
+		Title:       "OG title",
+		Description: "OG description",
+		Image:       server.URL + "/images/card.png",
+		SiteName:    "Example",
+	}
+	if preview != want {
+		t.Errorf("got %+v, want %+v", preview, want)
+	}
+}
+
+func TestFetchTwitterCard(t *testing.T) {
+	server := serveHTML(`<html><head>
+		<title>Page title</title>
+		<meta name="twitter:title" content="Twitter title">
+		<meta name="twitter:description" content="Twitter description">
+		<meta name="twitter:image:src" content="https://cdn.example.com/card.jpg">
+	</head></html>`)
+	defer server.Close()
+
+	preview, err := testFetcher().Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %s", err)
+	}
+	if preview.Title != "Twitter title" || preview.Description != "Twitter description" || preview.Image != "https://cdn.example.com/card.jpg" {
+		t.Errorf("got %+v", preview)
+	}
+}
+
+func TestFetchFallsBackToTitle(t *testing.T) {
+	server := serveHTML(`<html><head><title> Just a title </title>
+		<meta name="description" content="Plain description"></head></html>`)
+	defer server.Close()
+
+	preview, err := testFetcher().Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %s", err)
+	}
+	if preview.Title != "Just a title" || preview.Description != "Plain description" {
+		t.Errorf("got %+v", preview)
+	}
+}
+
+func TestFetchRejectsNonHTML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"title":"nope"}`)
+	}))
+	defer server.Close()
+
+	_, err := testFetcher().Fetch(context.Background(), server.URL)
+	if !errors.Is(err, ErrNotHTML) {
+		t.Errorf("Fetch = %v, want %v", err, ErrNotHTML)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	fetcher := testFetcher()
+	fetcher.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := fetcher.Fetch(context.Background(), server.URL)
+	if err == nil {
+		t.Fatal("Fetch succeeded against a server that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Fetch took %s with a %s timeout", elapsed, fetcher.Timeout)
+	}
+}
+
+func TestFetchReadsAtMostMaxBytes(t *testing.T) {
+	padding := strings.Repeat("<meta name=\"filler\" content=\"x\">", 2000)
+	server := serveHTML(`<html><head><title>Early title</title>` + padding +
+		`<meta property="og:title" content="Past the limit"></head></html>`)
+	defer server.Close()
+
+	fetcher := testFetcher()
+	fetcher.MaxBytes = 4096
+
+	preview, err := fetcher.Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %s", err)
+	}
+	if preview.Title != "Early title" {
+		t.Errorf("Title = %q, want the <title> from before the limit", preview.Title)
+	}
+}
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	hit := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer server.Close()
+
+	blocked := []net.IP{}
+	fetcher := NewFetcher()
+	fetcher.IsBlocked = func(ip net.IP) bool {
+		blocked = append(blocked, ip)
+		return IsPrivateIP(ip)
+	}
+
+	_, err := fetcher.Fetch(context.Background(), server.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Fetch = %v, want %v", err, ErrBlockedAddress)
+	}
+	if hit {
+		t.Error("request reached the loopback server")
+	}
+	if len(blocked) == 0 || !blocked[0].IsLoopback() {
+		t.Errorf("IsBlocked was called with %v, want the resolved loopback address", blocked)
+	}
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	private := []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.0.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1", "224.0.0.1"}
+	for _, addr := range private {
+		if !IsPrivateIP(net.ParseIP(addr)) {
+			t.Errorf("IsPrivateIP(%s) = false, want true", addr)
+		}
+	}
+	public := []string{"93.184.216.34", "8.8.8.8", "100.128.0.1", "2606:4700::1111"}
+	for _, addr := range public {
+		if IsPrivateIP(net.ParseIP(addr)) {
+			t.Errorf("IsPrivateIP(%s) = true, want false", addr)
+		}
+	}
+}
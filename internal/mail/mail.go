@@ -0,0 +1,87 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers outgoing email. Handlers only depend on this interface so
+// local development can log messages instead of talking to an SMTP server.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func NewSMTPMailer(host string, port string, from string, username string, password string) *SMTPMailer {
+	if port == "" {
+		port = "587"
+	}
+	return &SMTPMailer{
+		Addr:     host + ":" + port,
+		From:     from,
+		Username: username,
+		Password: password,
+	}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var smtpAuth smtp.Auth
+	if m.Username != "" {
+		host := strings.Split(m.Addr, ":")[0]
+		smtpAuth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	data := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", m.From, msg.To, msg.Subject, msg.Body)
+	return smtp.SendMail(m.Addr, smtpAuth, m.From, []string{msg.To}, []byte(data))
+}
+
+// LogMailer writes messages to a file, or to the standard logger when no path
+// is set. It is meant for local testing.
+type LogMailer struct {
+	path string
+	mu   *sync.Mutex
+}
+
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{
+		path: path,
+		mu:   &sync.Mutex{},
+	}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	entry := fmt.Sprintf("[%s] To: %s\nSubject: %s\n\n%s\n\n", time.Now().UTC().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	if m.path == "" {
+		log.Print(entry)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(entry)
+	return err
+}
@@ -0,0 +1,103 @@
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores immutable blobs addressed by the SHA-256 of their
+// contents, so storing the same bytes twice yields the same key.
+type BlobStore interface {
+	Put(data []byte) (key string, err error)
+	Open(key string) (io.ReadSeekCloser, error)
+	Delete(key string) error
+}
+
+// DiskStore keeps blobs under root, fanned out into directories by the first
+// bytes of the key.
+type DiskStore struct {
+	root string
+}
+
+func NewDiskStore(root string) (*DiskStore, error) {
+	err := os.MkdirAll(root, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskStore{root: root}, nil
+}
+
+func (store *DiskStore) Put(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	path := store.path(key)
+
+	if _, err := os.Stat(path); err == nil {
+		return key, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial blob
+	// under its final key
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return key, os.Rename(tmp.Name(), path)
+}
+
+func (store *DiskStore) Open(key string) (io.ReadSeekCloser, error) {
+	if !validKey(key) {
+		return nil, ErrBlobNotFound
+	}
+
+	file, err := os.Open(store.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return file, err
+}
+
+func (store *DiskStore) Delete(key string) error {
+	if !validKey(key) {
+		return ErrBlobNotFound
+	}
+
+	err := os.Remove(store.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (store *DiskStore) path(key string) string {
+	return filepath.Join(store.root, key[:2], key[2:4], key)
+}
+
+func validKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
@@ -0,0 +1,206 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	ThumbnailSize = 320
+
+	// maxPixels stops decompression bombs: a tiny file that decodes to an
+	// enormous image. For animated GIFs it covers every frame together.
+	maxPixels    = 40_000_000
+	maxGIFFrames = 500
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported media type")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+	ErrTooManyFrames   = errors.New("animation has too many frames")
+)
+
+// Processed is an upload after it has been re-encoded.
+type Processed struct {
+	ContentType          string
+	Data                 []byte
+	Width                int
+	Height               int
+	Thumbnail            []byte
+	ThumbnailContentType string
+}
+
+// Process sniffs the upload's type from its contents, ignoring whatever the
+// client claimed, and re-encodes it. Re-encoding drops EXIF and any other
+// metadata, including GPS coordinates embedded by phones, so JPEGs are
+// rotated upright first.
+func Process(data []byte) (Processed, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return Processed{}, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Processed{}, ErrUnsupportedType
+	}
+	if config.Width*config.Height > maxPixels {
+		return Processed{}, ErrTooManyPixels
+	}
+	// Every frame is decoded at up to the full canvas size, so count them
+	// before decoding any
+	if contentType == "image/gif" {
+		frames, err := gifFrameCount(data)
+		if err != nil {
+			return Processed{}, ErrUnsupportedType
+		}
+		if frames > maxGIFFrames {
+			return Processed{}, ErrTooManyFrames
+		}
+		if frames*config.Width*config.Height > maxPixels {
+			return Processed{}, ErrTooManyPixels
+		}
+	}
+
+	processed := Processed{
+		ContentType: contentType,
+		Width:       config.Width,
+		Height:      config.Height,
+	}
+
+	var img image.Image
+	buf := &bytes.Buffer{}
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if err == nil {
+			img = orient(img, jpegOrientation(data))
+			processed.Width, processed.Height = img.Bounds().Dx(), img.Bounds().Dy()
+			err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 90})
+		}
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+		if err == nil {
+			err = png.Encode(buf, img)
+		}
+	case "image/gif":
+		// Keep every frame so animations survive; comments and application
+		// extensions are not written back out
+		var animation *gif.GIF
+		animation, err = gif.DecodeAll(bytes.NewReader(data))
+		if err == nil {
+			img = animation.Image[0]
+			err = gif.EncodeAll(buf, animation)
+		}
+	}
+	if err != nil {
+		return Processed{}, ErrUnsupportedType
+	}
+	processed.Data = buf.Bytes()
+
+	processed.Thumbnail, processed.ThumbnailContentType, err = thumbnail(img, contentType)
+	if err != nil {
+		return Processed{}, err
+	}
+
+	return processed, nil
+}
+
+// gifFrameCount walks the blocks of a GIF without decompressing any image
+// data and returns how many frames it has.
+func gifFrameCount(data []byte) (int, error) {
+	errMalformed := errors.New("malformed gif")
+
+	// Header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, errMalformed
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+
+	// skipSubBlocks moves past a run of data sub-blocks and its terminator
+	skipSubBlocks := func() error {
+		for {
+			if pos >= len(data) {
+				return errMalformed
+			}
+			size := int(data[pos])
+			pos++
+			if size == 0 {
+				return nil
+			}
+			pos += size
+		}
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: introducer, label, sub-blocks
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		case 0x2c: // image descriptor, optional local color table, LZW data
+			if pos+10 > len(data) {
+				return 0, errMalformed
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			// LZW minimum code size
+			pos++
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+			frames++
+		case 0x3b: // trailer
+			return frames, nil
+		default:
+			return 0, errMalformed
+		}
+	}
+	// The standard decoder tolerates a missing trailer
+	return frames, nil
+}
+
+// thumbnail scales img to fit within ThumbnailSize, keeping its aspect
+// ratio. Photos become JPEGs; everything else becomes a PNG to keep
+// transparency.
+func thumbnail(img image.Image, contentType string) ([]byte, string, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > ThumbnailSize || height > ThumbnailSize {
+		if width >= height {
+			height = max(1, height*ThumbnailSize/width)
+			width = ThumbnailSize
+		} else {
+			width = max(1, width*ThumbnailSize/height)
+			height = ThumbnailSize
+		}
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+
+	buf := &bytes.Buffer{}
+	if contentType == "image/jpeg" {
+		err := jpeg.Encode(buf, scaled, &jpeg.Options{Quality: 80})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err := png.Encode(buf, scaled)
+	return buf.Bytes(), "image/png", err
+}
@@ -0,0 +1,184 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+)
+
+var (
+	red  = color.RGBA{255, 0, 0, 255}
+	blue = color.RGBA{0, 0, 255, 255}
+)
+
+// halves returns a JPEG whose left half is red and right half is blue, with
+// an EXIF Orientation tag when orientation is not 0.
+func halves(t *testing.T, width, height int, orientation int, order binary.ByteOrder) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 95})
+	if err != nil {
+		t.Fatalf("jpeg.Encode: %s", err)
+	}
+	data := buf.Bytes()
+	if orientation == 0 {
+		return data
+	}
+
+	// TIFF header, IFD0 with a single Orientation entry
+	tiff := make([]byte, 26)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	withExif := append([]byte{}, data[:2]...)
+	withExif = append(withExif, app1...)
+	return append(withExif, data[2:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xc000 && g < 0x4000 && b < 0x4000
+}
+
+func isBlue(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return b > 0xc000 && r < 0x4000 && g < 0x4000
+}
+
+func TestProcessAppliesEXIFOrientation(t *testing.T) {
+	tests := []struct {
+		name        string
+		orientation int
+		order       binary.ByteOrder
+		width       int
+		height      int
+		redAt       image.Point
+		blueAt      image.Point
+	}{
+		{"no tag", 0, binary.BigEndian, 80, 40, image.Pt(10, 20), image.Pt(70, 20)},
+		{"upright", 1, binary.BigEndian, 80, 40, image.Pt(10, 20), image.Pt(70, 20)},
+		{"mirrored", 2, binary.LittleEndian, 80, 40, image.Pt(70, 20), image.Pt(10, 20)},
+		{"upside down", 3, binary.BigEndian, 80, 40, image.Pt(70, 20), image.Pt(10, 20)},
+		{"quarter turn clockwise", 6, binary.LittleEndian, 40, 80, image.Pt(20, 10), image.Pt(20, 70)},
+		{"quarter turn anticlockwise", 8, binary.BigEndian, 40, 80, image.Pt(20, 70), image.Pt(20, 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processed, err := Process(halves(t, 80, 40, tt.orientation, tt.order))
+			if err != nil {
+				t.Fatalf("Process: %s", err)
+			}
+			if processed.Width != tt.width || processed.Height != tt.height {
+				t.Fatalf("size %dx%d, want %dx%d", processed.Width, processed.Height, tt.width, tt.height)
+			}
+
+			img, err := jpeg.Decode(bytes.NewReader(processed.Data))
+			if err != nil {
+				t.Fatalf("decoding output: %s", err)
+			}
+			if img.Bounds().Dx() != tt.width || img.Bounds().Dy() != tt.height {
+				t.Fatalf("output is %v, want %dx%d", img.Bounds(), tt.width, tt.height)
+			}
+			if !isRed(img.At(tt.redAt.X, tt.redAt.Y)) {
+				t.Errorf("pixel at %v is %v, want red", tt.redAt, img.At(tt.redAt.X, tt.redAt.Y))
+			}
+			if !isBlue(img.At(tt.blueAt.X, tt.blueAt.Y)) {
+				t.Errorf("pixel at %v is %v, want blue", tt.blueAt, img.At(tt.blueAt.X, tt.blueAt.Y))
+			}
+			if bytes.Contains(processed.Data, []byte("Exif")) {
+				t.Error("output still has EXIF data")
+			}
+		})
+	}
+}
+
+func animation(t *testing.T, frames int, width, height int) []byte {
+	t.Helper()
+
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		frame.SetColorIndex(i%width, 0, uint8(i))
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 5)
+	}
+	buf := &bytes.Buffer{}
+	err := gif.EncodeAll(buf, anim)
+	if err != nil {
+		t.Fatalf("gif.EncodeAll: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFFrameCount(t *testing.T) {
+	for _, frames := range []int{1, 2, 17} {
+		data := animation(t, frames, 16, 8)
+		got, err := gifFrameCount(data)
+		if err != nil {
+			t.Fatalf("gifFrameCount: %s", err)
+		}
+		if got != frames {
+			t.Errorf("gifFrameCount = %d, want %d", got, frames)
+		}
+	}
+
+	_, err := gifFrameCount([]byte("GIF89a"))
+	if err == nil {
+		t.Error("gifFrameCount accepted a truncated file")
+	}
+}
+
+func TestProcessLimitsGIFFrames(t *testing.T) {
+	processed, err := Process(animation(t, 3, 16, 8))
+	if err != nil {
+		t.Fatalf("Process: %s", err)
+	}
+	decoded, err := gif.DecodeAll(bytes.NewReader(processed.Data))
+	if err != nil || len(decoded.Image) != 3 {
+		t.Fatalf("animation did not survive re-encoding: %v", err)
+	}
+
+	_, err = Process(animation(t, maxGIFFrames+1, 2, 2))
+	if !errors.Is(err, ErrTooManyFrames) {
+		t.Errorf("Process with %d frames = %v, want %v", maxGIFFrames+1, err, ErrTooManyFrames)
+	}
+
+	// Each frame is small enough on its own but not all of them together
+	frames := maxPixels/(1000*1000) + 1
+	_, err = Process(animation(t, frames, 1000, 1000))
+	if !errors.Is(err, ErrTooManyPixels) {
+		t.Errorf("Process with %d 1000x1000 frames = %v, want %v", frames, err, ErrTooManyPixels)
+	}
+}
@@ -0,0 +1,159 @@
+package pubsub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+const (
+	EventChirpCreated        = "chirp.created"
+	EventChirpDeleted        = "chirp.deleted"
+	EventNotificationCreated = "notification.created"
+	EventUserDeleted         = "user.deleted"
+)
+
+// Event is a chirp change, for notification events the notification that
+// was created, or for user.deleted the id of the deleted account. IDs are "<epoch>-<sequence>" where epoch changes
+// every time the process starts, so a client resuming across a restart can be
+// told that events were missed.
+type Event struct {
+	ID           string
+	Seq          uint64
+	Type         string
+	Chirp        db.Chirp
+	Notification db.Notification
+	UserID       int
+}
+
+// Subscription receives events on C. If the subscriber falls behind, C is
+// closed and the subscriber should reconnect with its last event ID.
+type Subscription struct {
+	C      chan Event
+	filter func(Event) bool
+}
+
+// Broker fans chirp events out to subscribers and keeps a bounded history so
+// reconnecting clients can catch up.
+type Broker struct {
+	mu          *sync.Mutex
+	epoch       string
+	seq         uint64
+	history     []Event
+	historySize int
+	bufferSize  int
+	subs        map[*Subscription]struct{}
+}
+
+func NewBroker(historySize int, bufferSize int) *Broker {
+	return &Broker{
+		mu:          &sync.Mutex{},
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		historySize: historySize,
+		bufferSize:  bufferSize,
+		subs:        map[*Subscription]struct{}{},
+	}
+}
+
+// ChirpListener adapts the broker to db.DB's chirp listener hook.
+func (b *Broker) ChirpListener(event string, chirp db.Chirp) {
+	switch event {
+	case db.ChirpCreated:
+		b.Publish(EventChirpCreated, chirp)
+	case db.ChirpDeleted:
+		b.Publish(EventChirpDeleted, chirp)
+	}
+}
+
+// Publish never blocks; subscribers whose buffers are full are dropped.
+func (b *Broker) Publish(eventType string, chirp db.Chirp) {
+	b.publish(Event{Type: eventType, Chirp: chirp})
+}
+
+// PublishNotification sends a newly created notification to subscribers.
+func (b *Broker) PublishNotification(notification db.Notification) {
+	b.publish(Event{Type: EventNotificationCreated, Notification: notification})
+}
+
+// PublishUserDeleted tells subscribers streaming as userId that the account
+// is gone, so they can close their connections.
+func (b *Broker) PublishUserDeleted(userId int) {
+	b.publish(Event{Type: EventUserDeleted, UserID: userId})
+}
+
+func (b *Broker) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.ID = fmt.Sprintf("%s-%d", b.epoch, b.seq)
+	event.Seq = b.seq
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subs {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.C <- event:
+		default:
+			delete(b.subs, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// Subscribe registers a subscriber and returns the events it missed since
+// lastEventID. complete is false when those events can no longer be replayed,
+// either because the ID is from before a restart or it fell out of history.
+func (b *Broker) Subscribe(lastEventID string, filter func(Event) bool) (sub *Subscription, missed []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{
+		C:      make(chan Event, b.bufferSize),
+		filter: filter,
+	}
+	b.subs[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+
+	epoch, seqString, found := strings.Cut(lastEventID, "-")
+	lastSeq, err := strconv.ParseUint(seqString, 10, 64)
+	if !found || err != nil || epoch != b.epoch || lastSeq > b.seq {
+		return sub, nil, false
+	}
+
+	complete = lastSeq == b.seq || (len(b.history) > 0 && b.history[0].Seq <= lastSeq+1)
+	for _, event := range b.history {
+		if event.Seq <= lastSeq {
+			continue
+		}
+		if filter != nil && !filter(event) {
+			continue
+		}
+		missed = append(missed, event)
+	}
+
+	return sub, missed, complete
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.subs[sub]; exists {
+		delete(b.subs, sub)
+		close(sub.C)
+	}
+}
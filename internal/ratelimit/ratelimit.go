@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often Allow looks for keys with no recent events.
+const sweepInterval = time.Minute
+
+// Limiter is an in-memory sliding window limiter keyed by an arbitrary
+// string. Limits are passed per call so different users can have different
+// allowances.
+type Limiter struct {
+	mu        *sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+type window struct {
+	length time.Duration
+	events []time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{
+		mu:      &sync.Mutex{},
+		windows: map[string]*window{},
+	}
+}
+
+// Allow records an event for key if fewer than limit events happened within
+// length, otherwise it reports how long until the next event is allowed. A
+// limit of zero or less means unlimited.
+func (l *Limiter) Allow(key string, limit int, length time.Duration, now time.Time) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	w, exists := l.windows[key]
+	if !exists {
+		w = &window{}
+		l.windows[key] = w
+	}
+	w.length = length
+	w.prune(now)
+
+	if len(w.events) >= limit {
+		return false, w.events[0].Sub(now.Add(-length))
+	}
+
+	w.events = append(w.events, now)
+	return true, 0
+}
+
+// Refund removes the event Allow recorded for key at at, for when the action
+// it allowed didn't happen after all.
+func (l *Limiter) Refund(key string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, exists := l.windows[key]
+	if !exists {
+		return
+	}
+	for i := len(w.events) - 1; i >= 0; i-- {
+		if w.events[i].Equal(at) {
+			w.events = slices.Delete(w.events, i, i+1)
+			break
+		}
+	}
+	if len(w.events) == 0 {
+		delete(l.windows, key)
+	}
+}
+
+// sweep forgets keys whose events have all left their window, so every user
+// and address ever seen doesn't stay in memory. It runs at most once per
+// sweepInterval.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, w := range l.windows {
+		w.prune(now)
+		if len(w.events) == 0 {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// prune drops events older than the window.
+func (w *window) prune(now time.Time) {
+	cutoff := now.Add(-w.length)
+	recent := w.events[:0]
+	for _, t := range w.events {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	w.events = recent
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowSlidingWindow(t *testing.T) {
+	limiter := New()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		allowed, _ := limiter.Allow("user", 3, time.Hour, start.Add(time.Duration(i)*10*time.Minute))
+		if !allowed {
+			t.Fatalf("event %d was refused", i+1)
+		}
+	}
+
+	allowed, wait := limiter.Allow("user", 3, time.Hour, start.Add(30*time.Minute))
+	if allowed {
+		t.Fatal("fourth event within the hour was allowed")
+	}
+	if wait != 30*time.Minute {
+		t.Errorf("wait = %s, want 30m until the first event leaves the window", wait)
+	}
+
+	// Other keys have their own windows
+	allowed, _ = limiter.Allow("other", 3, time.Hour, start.Add(30*time.Minute))
+	if !allowed {
+		t.Error("a different key was limited")
+	}
+
+	// The window slides: once the first event is an hour old there is room
+	allowed, _ = limiter.Allow("user", 3, time.Hour, start.Add(time.Hour))
+	if !allowed {
+		t.Error("event was refused after the oldest one left the window")
+	}
+	allowed, _ = limiter.Allow("user", 3, time.Hour, start.Add(time.Hour+time.Minute))
+	if allowed {
+		t.Error("event was allowed while the window was full again")
+	}
+}
+
+func TestAllowUnlimited(t *testing.T) {
+	limiter := New()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 100; i++ {
+		allowed, _ := limiter.Allow("user", 0, time.Hour, now)
+		if !allowed {
+			t.Fatal("a limit of zero refused an event")
+		}
+	}
+	if len(limiter.windows) != 0 {
+		t.Error("unlimited events were recorded")
+	}
+}
+
+func TestRefund(t *testing.T) {
+	limiter := New()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	limiter.Allow("user", 1, time.Hour, now)
+	limiter.Refund("user", now)
+
+	allowed, _ := limiter.Allow("user", 1, time.Hour, now.Add(time.Second))
+	if !allowed {
+		t.Error("refunded event still counted against the limit")
+	}
+}
+
+func TestSweepForgetsIdleKeys(t *testing.T) {
+	limiter := New()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	limiter.Allow("short", 5, time.Minute, start)
+	limiter.Allow("long", 5, 24*time.Hour, start)
+
+	// The first call sweeps straight away, so the next sweep is a minute on
+	limiter.Allow("active", 5, time.Hour, start.Add(2*time.Minute))
+	if _, exists := limiter.windows["short"]; exists {
+		t.Error("key with no events left in its window was kept")
+	}
+	if _, exists := limiter.windows["long"]; !exists {
+		t.Error("key with events still in its window was dropped")
+	}
+
+	limiter.Allow("active", 5, time.Hour, start.Add(25*time.Hour))
+	if len(limiter.windows) != 1 {
+		t.Errorf("%d keys kept after a day, want only the active one", len(limiter.windows))
+	}
+}
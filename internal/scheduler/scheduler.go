@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+// Clock lets tests control what time the scheduler thinks it is.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the real wall clock.
+var SystemClock Clock = systemClock{}
+
+// Scheduler publishes scheduled chirps when they come due.
+type Scheduler struct {
+	DB    *db.DB
+	Clock Clock
+	// OnPublish is called for each chirp the scheduler publishes.
+	OnPublish func(chirp db.Chirp)
+}
+
+func New(database *db.DB, clock Clock) *Scheduler {
+	return &Scheduler{
+		DB:    database,
+		Clock: clock,
+	}
+}
+
+// PublishDue publishes every chirp that is due as of the scheduler's clock.
+func (s *Scheduler) PublishDue() ([]db.Chirp, error) {
+	published, err := s.DB.PublishDueChirps(s.Clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if s.OnPublish != nil {
+		for _, chirp := range published {
+			s.OnPublish(chirp)
+		}
+	}
+	return published, nil
+}
+
+// Run checks for due chirps every interval until the process exits.
+func (s *Scheduler) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		published, err := s.PublishDue()
+		if err != nil {
+			log.Printf("Could not publish scheduled chirps: %s", err)
+		} else if len(published) > 0 {
+			log.Printf("Published %d scheduled chirps", len(published))
+		}
+		<-ticker.C
+	}
+}
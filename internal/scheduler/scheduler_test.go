@@ -0,0 +1,177 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// recorder counts ChirpCreated events per chirp id.
+type recorder struct {
+	mu      sync.Mutex
+	created map[int]int
+}
+
+func (rec *recorder) listener(event string, chirp db.Chirp) {
+	if event != db.ChirpCreated {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.created[chirp.ID]++
+}
+
+func openDB(t *testing.T, path string) (*db.DB, *recorder) {
+	t.Helper()
+
+	database, err := db.NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	rec := &recorder{created: map[int]int{}}
+	database.AddChirpListener(rec.listener)
+	return database, rec
+}
+
+func schedule(t *testing.T, database *db.DB, body string, publishAt time.Time) db.Chirp {
+	t.Helper()
+
+	chirp, err := database.CreateChirp(db.Chirp{
+		Body:      body,
+		AuthorID:  1,
+		Status:    db.ChirpStatusScheduled,
+		PublishAt: publishAt,
+	})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+	return chirp
+}
+
+func TestPublishDue(t *testing.T) {
+	database, rec := openDB(t, filepath.Join(t.TempDir(), "database.json"))
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	first := schedule(t, database, "first", clock.now.Add(time.Hour))
+	second := schedule(t, database, "second", clock.now.Add(2*time.Hour))
+
+	s := New(database, clock)
+	onPublish := []int{}
+	s.OnPublish = func(chirp db.Chirp) {
+		onPublish = append(onPublish, chirp.ID)
+	}
+
+	published, err := s.PublishDue()
+	if err != nil {
+		t.Fatalf("PublishDue: %s", err)
+	}
+	if len(published) != 0 {
+		t.Fatalf("published %d chirps before any were due", len(published))
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	published, err = s.PublishDue()
+	if err != nil {
+		t.Fatalf("PublishDue: %s", err)
+	}
+	if len(published) != 1 || published[0].ID != first.ID {
+		t.Fatalf("published %+v, want only chirp %d", published, first.ID)
+	}
+	chirp, err := database.GetChirp(first.ID)
+	if err != nil {
+		t.Fatalf("GetChirp: %s", err)
+	}
+	if !chirp.Published() || !chirp.CreatedAt.Equal(clock.now) || !chirp.PublishAt.IsZero() {
+		t.Errorf("after publishing got %+v", chirp)
+	}
+	chirp, err = database.GetChirp(second.ID)
+	if err != nil {
+		t.Fatalf("GetChirp: %s", err)
+	}
+	if chirp.Status != db.ChirpStatusScheduled {
+		t.Errorf("chirp %d status = %q before it was due", second.ID, chirp.Status)
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	_, err = s.PublishDue()
+	if err != nil {
+		t.Fatalf("PublishDue: %s", err)
+	}
+	if len(onPublish) != 2 || onPublish[0] != first.ID || onPublish[1] != second.ID {
+		t.Errorf("OnPublish called for %v, want [%d %d]", onPublish, first.ID, second.ID)
+	}
+	if rec.created[first.ID] != 1 || rec.created[second.ID] != 1 {
+		t.Errorf("ChirpCreated counts %v, want one each", rec.created)
+	}
+}
+
+func TestPublishDueAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	before, _ := openDB(t, path)
+	later := schedule(t, before, "later", start.Add(2*time.Hour))
+	earlier := schedule(t, before, "earlier", start.Add(time.Hour))
+	future := schedule(t, before, "future", start.Add(48*time.Hour))
+
+	// The server was down while both chirps came due
+	database, rec := openDB(t, path)
+	s := New(database, &fakeClock{now: start.Add(24 * time.Hour)})
+
+	published, err := s.PublishDue()
+	if err != nil {
+		t.Fatalf("PublishDue: %s", err)
+	}
+	if len(published) != 2 || published[0].ID != earlier.ID || published[1].ID != later.ID {
+		t.Fatalf("published %+v, want chirps %d then %d", published, earlier.ID, later.ID)
+	}
+	if rec.created[future.ID] != 0 {
+		t.Error("published a chirp that isn't due yet")
+	}
+
+	published, err = s.PublishDue()
+	if err != nil {
+		t.Fatalf("PublishDue: %s", err)
+	}
+	if len(published) != 0 {
+		t.Errorf("second run published %d chirps again", len(published))
+	}
+	if rec.created[earlier.ID] != 1 || rec.created[later.ID] != 1 {
+		t.Errorf("ChirpCreated counts %v, want one each", rec.created)
+	}
+}
+
+func TestPublishNowRacingScheduler(t *testing.T) {
+	database, rec := openDB(t, filepath.Join(t.TempDir(), "database.json"))
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	chirp := schedule(t, database, "race", clock.now)
+	s := New(database, clock)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.PublishDue()
+		}()
+		go func() {
+			defer wg.Done()
+			database.PublishChirp(chirp.ID, clock.now)
+		}()
+	}
+	wg.Wait()
+
+	if rec.created[chirp.ID] != 1 {
+		t.Errorf("ChirpCreated sent %d times, want once", rec.created[chirp.ID])
+	}
+}
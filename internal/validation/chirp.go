@@ -0,0 +1,97 @@
+package validation
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrInvalidUTF8      = errors.New("chirp is not valid UTF-8")
+	ErrEmpty            = errors.New("chirp is empty")
+	ErrControlCharacter = errors.New("chirp contains control characters")
+	ErrTooLong          = errors.New("chirp is too long")
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// ChirpValidator measures chirps the way users see them: in grapheme
+// clusters, so an emoji with skin tone or a flag counts as one character,
+// and with every URL counting as URLWeight no matter how long it is.
+type ChirpValidator struct {
+	URLWeight int
+}
+
+func NewChirpValidator() *ChirpValidator {
+	return &ChirpValidator{
+		URLWeight: 23,
+	}
+}
+
+// Validate normalizes body to NFC and checks it against maxLength. The
+// normalized body is what should be stored.
+func (validator *ChirpValidator) Validate(body string, maxLength int) (string, error) {
+	body, err := normalize(body, true)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimFunc(body, isBlank) == "" {
+		return "", ErrEmpty
+	}
+
+	if validator.Length(body) > maxLength {
+		return "", ErrTooLong
+	}
+
+	return body, nil
+}
+
+// Length returns the length of body as counted against the chirp limit.
+func (validator *ChirpValidator) Length(body string) int {
+	length := 0
+	last := 0
+	for _, match := range urlPattern.FindAllStringIndex(body, -1) {
+		length += uniseg.GraphemeClusterCount(body[last:match[0]]) + validator.URLWeight
+		last = match[1]
+	}
+
+	return length + uniseg.GraphemeClusterCount(body[last:])
+}
+
+// normalize checks that text is valid UTF-8 without control characters and
+// returns it in NFC. Newlines and tabs are allowed when multiline is set.
+// Bidirectional overrides and isolates count as control characters, since
+// they can make text display differently from what it says.
+func normalize(text string, multiline bool) (string, error) {
+	if !utf8.ValidString(text) {
+		return "", ErrInvalidUTF8
+	}
+
+	text = norm.NFC.String(text)
+	for _, r := range text {
+		if multiline && (r == '\n' || r == '\t') {
+			continue
+		}
+		if unicode.IsControl(r) || isBidiControl(r) {
+			return "", ErrControlCharacter
+		}
+	}
+
+	return text, nil
+}
+
+// isBlank reports whether r shows as nothing: whitespace, or a format
+// character such as a zero width space. Format characters are still allowed
+// next to visible text, where they join emoji and shape some scripts.
+func isBlank(r rune) bool {
+	return unicode.IsSpace(r) || unicode.Is(unicode.Cf, r)
+}
+
+func isBidiControl(r rune) bool {
+	return (r >= '\u202A' && r <= '\u202E') || (r >= '\u2066' && r <= '\u2069')
+}
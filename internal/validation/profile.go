@@ -0,0 +1,70 @@
+package validation
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/rivo/uniseg"
+)
+
+const (
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+)
+
+var (
+	ErrInvalidHandle  = errors.New("handle must be 3-15 letters, numbers or underscores")
+	ErrReservedHandle = errors.New("handle is reserved")
+)
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,15}$`)
+
+// reservedHandles can't be claimed because they collide with routes under
+// /api/users or could be mistaken for staff accounts.
+var reservedHandles = map[string]bool{
+	"admin":    true,
+	"chirpy":   true,
+	"export":   true,
+	"me":       true,
+	"privacy":  true,
+	"profile":  true,
+	"support":  true,
+	"verify":   true,
+	"settings": true,
+}
+
+// ValidateHandle checks a handle chosen by a user. Handles keep the case they
+// were chosen with but are unique ignoring case.
+func ValidateHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return ErrInvalidHandle
+	}
+	if reservedHandles[strings.ToLower(handle)] {
+		return ErrReservedHandle
+	}
+	return nil
+}
+
+// ValidateDisplayName returns name trimmed and normalized to NFC. An empty
+// name is allowed and clears it.
+func ValidateDisplayName(name string) (string, error) {
+	return validateProfileText(name, MaxDisplayNameLength, false)
+}
+
+// ValidateBio returns bio trimmed and normalized to NFC. Unlike display names,
+// bios can span several lines.
+func ValidateBio(bio string) (string, error) {
+	return validateProfileText(bio, MaxBioLength, true)
+}
+
+func validateProfileText(text string, maxLength int, multiline bool) (string, error) {
+	text, err := normalize(strings.TrimSpace(text), multiline)
+	if err != nil {
+		return "", err
+	}
+	if uniseg.GraphemeClusterCount(text) > maxLength {
+		return "", ErrTooLong
+	}
+	return text, nil
+}
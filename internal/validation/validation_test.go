@@ -0,0 +1,125 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLength(t *testing.T) {
+	validator := NewChirpValidator()
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"ascii", "hello", 5},
+		{"accented letters", "caf\u00E9", 4},
+		{"combining mark", "cafe\u0301", 4},
+		{"emoji with skin tone", "👋🏽", 1},
+		{"flag", "🇳🇿", 1},
+		{"zero width joiner sequence", "👩\u200D👩\u200D👧", 1},
+		{"url", "https://example.com/a/very/long/path?with=query", 23},
+		{"text around urls", "see http://a.io and https://b.io/x ok", 4 + 23 + 5 + 23 + 3},
+		{"url stops at whitespace", "https://example.com\tnext", 23 + 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validator.Length(tt.body)
+			if got != tt.want {
+				t.Errorf("Length(%q) = %d, want %d", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	validator := NewChirpValidator()
+
+	tests := []struct {
+		name      string
+		body      string
+		maxLength int
+		want      string
+		wantErr   error
+	}{
+		{"plain", "hello world", 140, "hello world", nil},
+		{"multiline", "line one\n\tline two", 140, "line one\n\tline two", nil},
+		{"normalized to NFC", "cafe\u0301", 140, "caf\u00E9", nil},
+		{"exactly the limit", strings.Repeat("a", 10), 10, strings.Repeat("a", 10), nil},
+		{"too long", strings.Repeat("a", 11), 10, "", ErrTooLong},
+		{"emoji count once", strings.Repeat("👋🏽", 10), 10, strings.Repeat("👋🏽", 10), nil},
+		{"url weight counts", "https://example.com", 22, "", ErrTooLong},
+		{"empty", "", 140, "", ErrEmpty},
+		{"whitespace only", " \n\t ", 140, "", ErrEmpty},
+		{"zero width space only", "\u200B", 140, "", ErrEmpty},
+		{"word joiners and spaces", "\u2060 \uFEFF\u2060", 140, "", ErrEmpty},
+		{"joiner between emoji", "👩\u200D💻", 140, "👩\u200D💻", nil},
+		{"right to left override", "abc\u202Egpj.exe", 140, "", ErrControlCharacter},
+		{"bidi isolate", "\u2067hello\u2069", 140, "", ErrControlCharacter},
+		{"nul", "a\x00b", 140, "", ErrControlCharacter},
+		{"escape", "a\x1b[31mred", 140, "", ErrControlCharacter},
+		{"invalid utf-8", "a\xffb", 140, "", ErrInvalidUTF8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validator.Validate(tt.body, tt.maxLength)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate(%q) error = %v, want %v", tt.body, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Validate(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateNFCEquivalence(t *testing.T) {
+	validator := NewChirpValidator()
+
+	// Precomposed and decomposed forms must be stored identically so they
+	// compare and count the same
+	composed, err := validator.Validate("\u00C5ngstr\u00F6m", 140)
+	if err != nil {
+		t.Fatalf("Validate: %s", err)
+	}
+	decomposed, err := validator.Validate("A\u030Angstro\u0308m", 140)
+	if err != nil {
+		t.Fatalf("Validate: %s", err)
+	}
+	if composed != decomposed {
+		t.Errorf("composed %q and decomposed %q normalize differently", composed, decomposed)
+	}
+}
+
+func TestValidateHandle(t *testing.T) {
+	tests := []struct {
+		handle  string
+		wantErr error
+	}{
+		{"alice", nil},
+		{"Alice_99", nil},
+		{"abc", nil},
+		{"abcdefghijklmno", nil},
+		{"ab", ErrInvalidHandle},
+		{"abcdefghijklmnop", ErrInvalidHandle},
+		{"has space", ErrInvalidHandle},
+		{"dash-ed", ErrInvalidHandle},
+		{"émile", ErrInvalidHandle},
+		{"admin", ErrReservedHandle},
+		{"Admin", ErrReservedHandle},
+		{"SUPPORT", ErrReservedHandle},
+		{"settings", ErrReservedHandle},
+		{"administrator", nil},
+		{"me", ErrInvalidHandle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.handle, func(t *testing.T) {
+			err := ValidateHandle(tt.handle)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateHandle(%q) = %v, want %v", tt.handle, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/linkpreview"
+)
+
+const (
+	EventChirpCreated = "chirp.created"
+	EventChirpDeleted = "chirp.deleted"
+	EventUserUpgraded = "user.upgraded"
+
+	maxAttempts    = 8
+	baseBackoff    = 30 * time.Second
+	maxBackoff     = 6 * time.Hour
+	batchSize      = 20
+	retention      = 30 * 24 * time.Hour
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	ErrInvalidURL     = errors.New("webhook URL must be an absolute http or https URL")
+	ErrBlockedAddress = errors.New("webhook URL must resolve to a public address")
+)
+
+var Events = map[string]bool{
+	EventChirpCreated: true,
+	EventChirpDeleted: true,
+	EventUserUpgraded: true,
+}
+
+type envelope struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Dispatcher queues outbound webhook deliveries in the database and delivers
+// them from a background loop, retrying failures with exponential backoff.
+// Delivery order is not guaranteed: a failed delivery is retried after later
+// ones have gone through, so receivers should order events by the envelope's
+// created_at and deduplicate them by id.
+// Like link previews, every connection is checked against IsBlocked after DNS
+// resolution so endpoints cannot reach internal services.
+type Dispatcher struct {
+	DB        *db.DB
+	Client    *http.Client
+	IsBlocked func(ip net.IP) bool
+	Now       func() time.Time
+	wake      chan struct{}
+}
+
+func NewDispatcher(database *db.DB) *Dispatcher {
+	d := &Dispatcher{
+		DB:        database,
+		IsBlocked: linkpreview.IsPrivateIP,
+		Now:       time.Now,
+		wake:      make(chan struct{}, 1),
+	}
+
+	dialer := &net.Dialer{
+		Timeout: requestTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || d.IsBlocked(ip) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+	d.Client = &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   requestTimeout,
+			ResponseHeaderTimeout: requestTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		// A redirect counts as a failed delivery rather than being followed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return d
+}
+
+// CheckURL rejects endpoint URLs that are not absolute http or https URLs or
+// whose host resolves to an address IsBlocked refuses. Deliveries are checked
+// again when they connect, since DNS can change after registration.
+func (d *Dispatcher) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+
+	ips := []net.IP{}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+		if err != nil {
+			return fmt.Errorf("could not resolve webhook host: %w", err)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if d.IsBlocked(ip) {
+			return ErrBlockedAddress
+		}
+	}
+	return nil
+}
+
+// Publish queues the event for every interested endpoint. subjectUserId is the
+// user the event is about; their own endpoints and admin endpoints receive it.
+func (d *Dispatcher) Publish(event string, subjectUserId int, data interface{}) {
+	now := d.Now().UTC()
+	_, err := d.DB.EnqueueWebhookDeliveries(event, subjectUserId, func(endpoint db.WebhookEndpoint) (db.WebhookDelivery, error) {
+		id, err := auth.MakeTokenID()
+		if err != nil {
+			return db.WebhookDelivery{}, err
+		}
+		id = id[:24]
+
+		payload, err := json.Marshal(envelope{
+			ID:        id,
+			Event:     event,
+			CreatedAt: now,
+			Data:      data,
+		})
+		if err != nil {
+			return db.WebhookDelivery{}, err
+		}
+
+		return db.WebhookDelivery{
+			ID:            id,
+			EndpointID:    endpoint.ID,
+			Event:         event,
+			Payload:       payload,
+			Status:        db.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}, nil
+	}, retention)
+	if err != nil {
+		log.Printf("Could not queue %s webhooks: %s", event, err)
+		return
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due webhooks until the process exits. It polls at interval so
+// deliveries queued before a restart, and retries, are picked up.
+func (d *Dispatcher) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue()
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue attempts every due delivery. Endpoints are delivered to
+// concurrently, so one slow endpoint can't hold up the others, while each
+// endpoint receives this batch one request at a time. A delivery waiting to
+// be retried doesn't hold back newer ones, so events can arrive out of order.
+func (d *Dispatcher) deliverDue() {
+	due, err := d.DB.DueWebhookDeliveries(d.Now().UTC(), batchSize)
+	if err != nil {
+		log.Printf("Could not load webhook deliveries: %s", err)
+		return
+	}
+
+	byEndpoint := map[string][]db.WebhookDelivery{}
+	for _, delivery := range due {
+		byEndpoint[delivery.EndpointID] = append(byEndpoint[delivery.EndpointID], delivery)
+	}
+
+	wg := sync.WaitGroup{}
+	for endpointId, deliveries := range byEndpoint {
+		endpoint, err := d.DB.GetWebhookEndpoint(endpointId)
+		if err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, delivery := range deliveries {
+				delivery = d.attempt(endpoint, delivery)
+				err := d.DB.UpdateWebhookDelivery(delivery)
+				if err != nil {
+					log.Printf("Could not record webhook delivery %s: %s", delivery.ID, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) attempt(endpoint db.WebhookEndpoint, delivery db.WebhookDelivery) db.WebhookDelivery {
+	now := d.Now().UTC()
+	delivery.Attempts++
+	delivery.LastAttemptAt = now
+
+	statusCode, err := d.send(endpoint, delivery, now)
+	delivery.LastStatusCode = statusCode
+	if err == nil {
+		delivery.Status = db.DeliverySucceeded
+		delivery.LastError = ""
+		return delivery
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= maxAttempts {
+		delivery.Status = db.DeliveryFailed
+		return delivery
+	}
+	delivery.NextAttemptAt = now.Add(backoff(delivery.Attempts))
+	return delivery
+}
+
+func (d *Dispatcher) send(endpoint db.WebhookEndpoint, delivery db.WebhookDelivery, now time.Time) (int, error) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set("Chirpy-Event", delivery.Event)
+	req.Header.Set("Chirpy-Delivery", delivery.ID)
+	req.Header.Set("Chirpy-Timestamp", timestamp)
+	req.Header.Set("Chirpy-Signature", "v1="+auth.SignWebhook(endpoint.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff doubles the wait after every failed attempt.
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
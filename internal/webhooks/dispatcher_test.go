@@ -0,0 +1,338 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is an httptest endpoint that records requests and answers with
+// the next status in statuses, then 200 once they run out.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedRequest
+}
+
+func (rec *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.requests = append(rec.requests, receivedRequest{header: r.Header.Clone(), body: body})
+	status := http.StatusOK
+	if len(rec.statuses) > 0 {
+		status = rec.statuses[0]
+		rec.statuses = rec.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rec *receiver) count() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return len(rec.requests)
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// newTestDispatcher returns a dispatcher on a fresh database with one admin
+// endpoint pointing at url. Loopback is allowed unless allowLoopback is
+// false, since httptest servers listen on 127.0.0.1.
+func newTestDispatcher(t *testing.T, url string, allowLoopback bool) (*Dispatcher, *fakeClock, db.WebhookEndpoint) {
+	t.Helper()
+
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	d := NewDispatcher(database)
+	d.Now = clock.Now
+	if allowLoopback {
+		d.IsBlocked = func(ip net.IP) bool { return false }
+	}
+
+	endpoint := db.WebhookEndpoint{
+		ID:        "endpoint1",
+		URL:       url,
+		Events:    []string{EventChirpCreated},
+		Secret:    "whsec_test",
+		CreatedAt: clock.now,
+	}
+	err = database.CreateWebhookEndpoint(endpoint)
+	if err != nil {
+		t.Fatalf("CreateWebhookEndpoint: %s", err)
+	}
+	return d, clock, endpoint
+}
+
+func onlyDelivery(t *testing.T, d *Dispatcher, endpointId string) db.WebhookDelivery {
+	t.Helper()
+
+	deliveries, err := d.DB.GetWebhookDeliveries(endpointId)
+	if err != nil {
+		t.Fatalf("GetWebhookDeliveries: %s", err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+func TestDeliverySignature(t *testing.T) {
+	rec := &receiver{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	d, _, endpoint := newTestDispatcher(t, server.URL, true)
+	d.Publish(EventChirpCreated, 1, map[string]string{"body": "hello"})
+	d.deliverDue()
+
+	if rec.count() != 1 {
+		t.Fatalf("receiver got %d requests, want 1", rec.count())
+	}
+	req := rec.requests[0]
+	if got := req.header.Get("Chirpy-Event"); got != EventChirpCreated {
+		t.Errorf("Chirpy-Event = %q, want %q", got, EventChirpCreated)
+	}
+	timestamp := req.header.Get("Chirpy-Timestamp")
+	want := "v1=" + auth.SignWebhook(endpoint.Secret, timestamp, req.body)
+	if got := req.header.Get("Chirpy-Signature"); got != want {
+		t.Errorf("Chirpy-Signature = %q, want %q", got, want)
+	}
+	err := auth.VerifyWebhook(endpoint.Secret, timestamp, req.header.Get("Chirpy-Signature"), req.body, d.Now(), 5*time.Minute)
+	if err != nil {
+		t.Errorf("VerifyWebhook: %s", err)
+	}
+
+	delivery := onlyDelivery(t, d, endpoint.ID)
+	if delivery.Status != db.DeliverySucceeded {
+		t.Errorf("status = %q, want %q", delivery.Status, db.DeliverySucceeded)
+	}
+	if req.header.Get("Chirpy-Delivery") != delivery.ID {
+		t.Errorf("Chirpy-Delivery = %q, want %q", req.header.Get("Chirpy-Delivery"), delivery.ID)
+	}
+}
+
+func TestDeliveryRetriesWithBackoff(t *testing.T) {
+	rec := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	d, clock, endpoint := newTestDispatcher(t, server.URL, true)
+	d.Publish(EventChirpCreated, 1, map[string]string{"body": "hello"})
+
+	d.deliverDue()
+	delivery := onlyDelivery(t, d, endpoint.ID)
+	if delivery.Status != db.DeliveryPending || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusInternalServerError {
+		t.Fatalf("after first attempt got %+v", delivery)
+	}
+	if want := clock.now.Add(baseBackoff); !delivery.NextAttemptAt.Equal(want) {
+		t.Fatalf("next attempt at %s, want %s", delivery.NextAttemptAt, want)
+	}
+
+	// Nothing is retried before the backoff has passed
+	clock.now = clock.now.Add(baseBackoff - time.Second)
+	d.deliverDue()
+	if rec.count() != 1 {
+		t.Fatalf("retried early: receiver got %d requests", rec.count())
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	d.deliverDue()
+	delivery = onlyDelivery(t, d, endpoint.ID)
+	if delivery.Attempts != 2 || delivery.Status != db.DeliveryPending {
+		t.Fatalf("after second attempt got %+v", delivery)
+	}
+	if want := clock.now.Add(2 * baseBackoff); !delivery.NextAttemptAt.Equal(want) {
+		t.Fatalf("next attempt at %s, want %s", delivery.NextAttemptAt, want)
+	}
+
+	clock.now = clock.now.Add(2 * baseBackoff)
+	d.deliverDue()
+	delivery = onlyDelivery(t, d, endpoint.ID)
+	if delivery.Attempts != 3 || delivery.Status != db.DeliverySucceeded || delivery.LastError != "" {
+		t.Fatalf("after third attempt got %+v", delivery)
+	}
+
+	// Every attempt sends the same bytes
+	for _, req := range rec.requests[1:] {
+		if string(req.body) != string(rec.requests[0].body) {
+			t.Errorf("retry body %s differs from %s", req.body, rec.requests[0].body)
+		}
+	}
+}
+
+func TestDeliveryGivesUpAfterMaxAttempts(t *testing.T) {
+	rec := &receiver{}
+	for i := 0; i < maxAttempts; i++ {
+		rec.statuses = append(rec.statuses, http.StatusServiceUnavailable)
+	}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	d, clock, endpoint := newTestDispatcher(t, server.URL, true)
+	d.Publish(EventChirpCreated, 1, map[string]string{"body": "hello"})
+	for i := 0; i < maxAttempts+2; i++ {
+		d.deliverDue()
+		clock.now = clock.now.Add(maxBackoff)
+	}
+
+	delivery := onlyDelivery(t, d, endpoint.ID)
+	if delivery.Status != db.DeliveryFailed || delivery.Attempts != maxAttempts {
+		t.Fatalf("got %+v, want failed after %d attempts", delivery, maxAttempts)
+	}
+	if rec.count() != maxAttempts {
+		t.Errorf("receiver got %d requests, want %d", rec.count(), maxAttempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{20, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDeliveryRefusesPrivateAddresses(t *testing.T) {
+	rec := &receiver{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	d, _, endpoint := newTestDispatcher(t, server.URL, false)
+	d.Publish(EventChirpCreated, 1, map[string]string{"body": "hello"})
+	d.deliverDue()
+
+	if rec.count() != 0 {
+		t.Fatalf("receiver on loopback got %d requests", rec.count())
+	}
+	delivery := onlyDelivery(t, d, endpoint.ID)
+	if delivery.Status != db.DeliveryPending || delivery.LastStatusCode != 0 || delivery.LastError == "" {
+		t.Errorf("got %+v, want a failed attempt with no response", delivery)
+	}
+}
+
+func TestDeliveryDoesNotFollowRedirects(t *testing.T) {
+	target := &receiver{}
+	targetServer := httptest.NewServer(target)
+	defer targetServer.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(targetServer.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	d, _, endpoint := newTestDispatcher(t, redirect.URL, true)
+	d.Publish(EventChirpCreated, 1, map[string]string{"body": "hello"})
+	d.deliverDue()
+
+	if target.count() != 0 {
+		t.Fatalf("redirect target got %d requests", target.count())
+	}
+	delivery := onlyDelivery(t, d, endpoint.ID)
+	if delivery.Status != db.DeliveryPending || delivery.LastStatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("got %+v, want a failed attempt with status 307", delivery)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	d := NewDispatcher(nil)
+
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"https://93.184.216.34/hook", nil},
+		{"http://[2606:2800:220:1:248:1893:25c8:1946]/hook", nil},
+		{"ftp://93.184.216.34/hook", ErrInvalidURL},
+		{"/relative", ErrInvalidURL},
+		{"http://127.0.0.1:8080/hook", ErrBlockedAddress},
+		{"http://localhost/hook", ErrBlockedAddress},
+		{"http://10.0.0.5/hook", ErrBlockedAddress},
+		{"http://192.168.1.1/hook", ErrBlockedAddress},
+		{"http://169.254.169.254/latest/meta-data", ErrBlockedAddress},
+		{"http://[::1]/hook", ErrBlockedAddress},
+		{"http://[fd00::1]/hook", ErrBlockedAddress},
+		{"http://0.0.0.0/hook", ErrBlockedAddress},
+	}
+	for _, tt := range tests {
+		err := d.CheckURL(context.Background(), tt.url)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("CheckURL(%q) = %v, want %v", tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestSlowEndpointDoesNotDelayOthers(t *testing.T) {
+	fastHit := make(chan struct{})
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(fastHit)
+	}))
+	defer fast.Close()
+	waited := false
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only answers once the other endpoint has been reached, which never
+		// happens if deliveries are made one after another starting here
+		select {
+		case <-fastHit:
+		case <-time.After(2 * time.Second):
+			waited = true
+		}
+	}))
+	defer slow.Close()
+
+	d, clock, _ := newTestDispatcher(t, slow.URL, true)
+	err := d.DB.CreateWebhookEndpoint(db.WebhookEndpoint{
+		ID:        "endpoint2",
+		URL:       fast.URL,
+		Events:    []string{EventChirpCreated},
+		Secret:    "whsec_other",
+		CreatedAt: clock.now,
+	})
+	if err != nil {
+		t.Fatalf("CreateWebhookEndpoint: %s", err)
+	}
+
+	d.Publish(EventChirpCreated, 1, map[string]string{"body": "hello"})
+	d.deliverDue()
+
+	if waited {
+		t.Fatal("slow endpoint held up delivery to the other endpoint")
+	}
+	for _, id := range []string{"endpoint1", "endpoint2"} {
+		if delivery := onlyDelivery(t, d, id); delivery.Status != db.DeliverySucceeded {
+			t.Errorf("%s: status = %q, want %q", id, delivery.Status, db.DeliverySucceeded)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+const (
+	linkPreviewTTL        = 24 * time.Hour
+	linkPreviewFailureTTL = time.Hour
+	maxLinkPreviews       = 10000
+)
+
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// queueLinkPreviews is registered as a chirp listener. Fetching happens on
+// the preview workers so posting a chirp never waits on a remote site; when
+// the queue is full the preview is skipped rather than blocking.
+func (cfg *apiConfig) queueLinkPreviews(event string, chirp db.Chirp) {
+	if event != db.ChirpCreated && event != db.ChirpUpdated {
+		return
+	}
+
+	for _, url := range chirp.URLs {
+		select {
+		case cfg.PreviewQueue <- url:
+		default:
+			log.Printf("Link preview queue is full, skipping %s", url)
+		}
+	}
+}
+
+// fetchLinkPreviews fetches queued URLs until the queue is closed.
+func (cfg *apiConfig) fetchLinkPreviews() {
+	for url := range cfg.PreviewQueue {
+		cached, exists, err := cfg.DB.GetLinkPreview(url)
+		if err != nil {
+			log.Printf("Couldn't read link preview cache: %s", err)
+			continue
+		}
+		if exists && linkPreviewFresh(cached, time.Now()) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.PreviewFetcher.Timeout)
+		preview, err := cfg.PreviewFetcher.Fetch(ctx, url)
+		cancel()
+
+		dbPreview := db.LinkPreview{
+			URL:         url,
+			Title:       preview.Title,
+			Description: preview.Description,
+			Image:       preview.Image,
+			SiteName:    preview.SiteName,
+			FetchedAt:   time.Now().UTC(),
+		}
+		if err != nil {
+			dbPreview.Error = err.Error()
+		}
+
+		err = cfg.DB.SaveLinkPreview(dbPreview, maxLinkPreviews)
+		if err != nil {
+			log.Printf("Couldn't save link preview for %s: %s", url, err)
+		}
+	}
+}
+
+func linkPreviewFresh(preview db.LinkPreview, now time.Time) bool {
+	ttl := linkPreviewTTL
+	if preview.Error != "" {
+		ttl = linkPreviewFailureTTL
+	}
+	return now.Sub(preview.FetchedAt) < ttl
+}
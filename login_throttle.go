@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	accountLockThreshold = 5
+	ipLockThreshold      = 20
+	baseLockout          = 30 * time.Second
+	maxLockout           = time.Hour
+	failureResetAfter    = time.Hour
+)
+
+var errUnknownAccount = errors.New("no account with that email")
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+func accountThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// lockoutDuration doubles the lockout for every failure past the threshold so
+// sustained guessing gets exponentially slower.
+func lockoutDuration(key string, failures int) time.Duration {
+	threshold := accountLockThreshold
+	if strings.HasPrefix(key, "ip:") {
+		threshold = ipLockThreshold
+	}
+	if failures < threshold {
+		return 0
+	}
+
+	lockout := baseLockout
+	for i := threshold; i < failures && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, maxLockout)
+}
+
+// loginLockedFor returns how long the caller must wait before any of the keys
+// may attempt to log in again.
+func (cfg *apiConfig) loginLockedFor(keys ...string) (time.Duration, error) {
+	now := time.Now().UTC()
+	wait := time.Duration(0)
+	for _, key := range keys {
+		attempt, err := cfg.DB.GetLoginAttempt(key)
+		if err != nil {
+			return 0, err
+		}
+		if attempt.LockedUntil.After(now) {
+			wait = max(wait, attempt.LockedUntil.Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+func (cfg *apiConfig) recordLoginFailure(keys ...string) {
+	err := cfg.DB.RecordLoginFailure(keys, time.Now().UTC(), failureResetAfter, lockoutDuration)
+	if err != nil {
+		log.Printf("Could not record failed login: %s", err)
+	}
+}
+
+func respondWithLockout(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later")
+}
+
+// checkPasswordUniform compares against a throwaway hash when the account does
+// not exist so both cases take the same time.
+func (cfg *apiConfig) checkPasswordUniform(password string, hash []byte) (bool, error) {
+	if len(hash) == 0 {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = cfg.Hasher.Hash("chirpy-dummy-password")
+		})
+		cfg.Hasher.Verify(password, dummyHash)
+		return false, errUnknownAccount
+	}
+	return cfg.Hasher.Verify(password, string(hash))
+}
+
+func (cfg *apiConfig) unlockUser(w http.ResponseWriter, r *http.Request) {
+	if !cfg.isAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Admin key required")
+		return
+	}
+
+	userId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "User id is not a number")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(userId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Could not find user")
+		return
+	}
+
+	err = cfg.DB.ClearLoginAttempts(accountThrottleKey(user.Email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not unlock user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
@@ -5,9 +5,22 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/Zmahl/chirpy/internal/auth"
 	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/entitlements"
+	"github.com/Zmahl/chirpy/internal/linkpreview"
+	"github.com/Zmahl/chirpy/internal/mail"
+	"github.com/Zmahl/chirpy/internal/media"
+	"github.com/Zmahl/chirpy/internal/pubsub"
+	"github.com/Zmahl/chirpy/internal/ratelimit"
+	"github.com/Zmahl/chirpy/internal/scheduler"
+	"github.com/Zmahl/chirpy/internal/validation"
+	"github.com/Zmahl/chirpy/internal/webhooks"
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type apiConfig struct {
@@ -15,6 +28,20 @@ type apiConfig struct {
 	DB             *db.DB
 	SecretString   string
 	PolkaKey       string
+	AdminKey       string
+	Mailer         mail.Mailer
+	Hasher         *auth.PasswordHasher
+	PasswordPolicy *auth.PasswordPolicy
+	Plans          entitlements.Plans
+	ChirpLimiter   *ratelimit.Limiter
+	Webhooks       *webhooks.Dispatcher
+	Broker         *pubsub.Broker
+	Notifications  *pubsub.Broker
+	Blobs          media.BlobStore
+	MaxMediaBytes  int64
+	PreviewFetcher *linkpreview.Fetcher
+	PreviewQueue   chan string
+	ChirpValidator *validation.ChirpValidator
 }
 
 func main() {
@@ -35,39 +62,209 @@ func main() {
 	jwtSecret := os.Getenv("JWT_SECRET")
 	polkaKey := os.Getenv("POLKA_KEY")
 
+	hasher, err := auth.NewPasswordHasher(os.Getenv("PASSWORD_HASH_ALGORITHM"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Bad values would make argon2 panic on the first hash or make every
+	// login rehash, so refuse to start instead
+	hasher.Argon2.Parallelism = uint8(envIntInRange("ARGON2_PARALLELISM", int(hasher.Argon2.Parallelism), 1, 255))
+	hasher.Argon2.Memory = uint32(envIntInRange("ARGON2_MEMORY_KIB", int(hasher.Argon2.Memory), 8*int(hasher.Argon2.Parallelism), 4<<20))
+	hasher.Argon2.Iterations = uint32(envIntInRange("ARGON2_ITERATIONS", int(hasher.Argon2.Iterations), 1, 1000))
+	hasher.BcryptCost = envIntInRange("BCRYPT_COST", hasher.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost)
+
+	passwordPolicy := auth.NewPasswordPolicy(envInt("PASSWORD_MIN_LENGTH", 8), 72)
+	if breachedPath := os.Getenv("BREACHED_PASSWORDS_FILE"); breachedPath != "" {
+		err = passwordPolicy.LoadBreachedPasswords(breachedPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	plans := entitlements.Default()
+	if plansPath := os.Getenv("ENTITLEMENTS_FILE"); plansPath != "" {
+		plans, err = entitlements.Load(plansPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var mailer mail.Mailer = mail.NewLogMailer(os.Getenv("MAIL_LOG_FILE"))
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		mailer = mail.NewSMTPMailer(
+			smtpHost,
+			os.Getenv("SMTP_PORT"),
+			os.Getenv("MAIL_FROM"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+		)
+	}
+
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "media"
+	}
+	blobs, err := media.NewDiskStore(mediaDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chirpValidator := validation.NewChirpValidator()
+	chirpValidator.URLWeight = envInt("CHIRP_URL_WEIGHT", chirpValidator.URLWeight)
+
+	broker := pubsub.NewBroker(1000, 64)
+	db.AddChirpListener(broker.ChirpListener)
+
 	config := &apiConfig{
 		fileServerHits: 0,
 		DB:             db,
 		SecretString:   jwtSecret,
 		PolkaKey:       polkaKey,
+		AdminKey:       os.Getenv("ADMIN_KEY"),
+		Mailer:         mailer,
+		Hasher:         hasher,
+		PasswordPolicy: passwordPolicy,
+		Plans:          plans,
+		ChirpLimiter:   ratelimit.New(),
+		Webhooks:       webhooks.NewDispatcher(db),
+		Broker:         broker,
+		Notifications:  pubsub.NewBroker(100, 16),
+		Blobs:          blobs,
+		MaxMediaBytes:  int64(envInt("MEDIA_MAX_BYTES", 5<<20)),
+		PreviewFetcher: linkpreview.NewFetcher(),
+		PreviewQueue:   make(chan string, 256),
+		ChirpValidator: chirpValidator,
 	}
+	db.AddChirpListener(config.chirpNotifications)
+	db.AddChirpListener(config.queueLinkPreviews)
 
-	mux := http.NewServeMux()
-	fileHandler := http.StripPrefix("/app", http.FileServer(http.Dir(".")))
-	mux.Handle("/app/*", config.middlewareMetricInc(fileHandler))
-	mux.HandleFunc("/api/reset", config.reset)
-	mux.HandleFunc("GET /api/healthz", checkHealth)
-	mux.HandleFunc("GET /admin/metrics", config.getMetrics)
-	mux.HandleFunc("POST /api/chirps", config.postChirp)
-	mux.HandleFunc("GET /api/chirps", config.getChirps)
-	mux.HandleFunc("GET /api/chirps/{id}", config.getSingleChirp)
-	mux.HandleFunc("POST /api/users", config.createUser)
-	mux.HandleFunc("POST /api/login", config.userLogin)
-	mux.HandleFunc("PUT /api/users", config.updateUser)
-	mux.HandleFunc("POST /api/refresh", config.refreshJWT)
-	mux.HandleFunc("POST /api/revoke", config.revokeJWT)
-	mux.HandleFunc("DELETE /api/chirps/{id}", config.deleteChirp)
-	mux.HandleFunc("POST /api/polka/webhooks", config.upgradeUser)
+	chirpScheduler := scheduler.New(db, scheduler.SystemClock)
+	chirpScheduler.OnPublish = config.scheduledChirpPublished
 
 	// Struct that describes server configuration
 	server := http.Server{
 		Addr:    ":8080",
-		Handler: mux,
+		Handler: config.routes(),
+	}
+
+	go config.expireSubscriptions(time.Minute)
+	go config.Webhooks.Run(5 * time.Second)
+	go chirpScheduler.Run(time.Second)
+	for i := 0; i < 4; i++ {
+		go config.fetchLinkPreviews()
 	}
 
 	server.ListenAndServe()
 }
 
+// routes registers every endpoint on a new mux.
+func (cfg *apiConfig) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	fileHandler := http.StripPrefix("/app", http.FileServer(http.Dir(".")))
+	mux.Handle("/app/*", cfg.middlewareMetricInc(fileHandler))
+	mux.HandleFunc("/api/reset", cfg.reset)
+	mux.HandleFunc("GET /api/healthz", checkHealth)
+	mux.HandleFunc("GET /admin/metrics", cfg.getMetrics)
+	mux.HandleFunc("POST /admin/users/{id}/unlock", cfg.unlockUser)
+	mux.HandleFunc("POST /api/chirps", cfg.postChirp)
+	mux.HandleFunc("GET /api/chirps", cfg.getChirps)
+	mux.HandleFunc("GET /api/chirps/stream", cfg.streamChirps)
+	mux.HandleFunc("GET /api/chirps/drafts", cfg.getDrafts)
+	mux.HandleFunc("POST /api/chirps/{id}/publish", cfg.publishChirp)
+	mux.HandleFunc("GET /api/ws", cfg.serveWebSocket)
+	mux.HandleFunc("GET /api/chirps/{id}", cfg.getSingleChirp)
+	mux.HandleFunc("POST /api/media", cfg.uploadMedia)
+	mux.HandleFunc("GET /api/media/{id}", cfg.getMedia)
+	mux.HandleFunc("GET /api/media/{id}/thumbnail", cfg.getMediaThumbnail)
+	mux.HandleFunc("POST /api/users", cfg.createUser)
+	mux.HandleFunc("POST /api/login", cfg.userLogin)
+	mux.HandleFunc("POST /api/login/mfa", cfg.loginMFA)
+	mux.HandleFunc("POST /api/mfa/totp/enroll", cfg.enrollTOTP)
+	mux.HandleFunc("POST /api/mfa/totp/confirm", cfg.confirmTOTP)
+	mux.HandleFunc("POST /api/mfa/totp/disable", cfg.disableTOTP)
+	mux.HandleFunc("POST /api/password/forgot", cfg.forgotPassword)
+	mux.HandleFunc("POST /api/password/reset", cfg.resetPassword)
+	mux.HandleFunc("PUT /api/users", cfg.updateUser)
+	mux.HandleFunc("DELETE /api/users", cfg.deleteUser)
+	mux.HandleFunc("GET /api/users/export", cfg.exportUser)
+	mux.HandleFunc("POST /api/users/verify", cfg.verifyUser)
+	mux.HandleFunc("POST /api/users/verify/resend", cfg.resendVerification)
+	mux.HandleFunc("POST /api/users/{id}/follow", cfg.followUser)
+	mux.HandleFunc("DELETE /api/users/{id}/follow", cfg.unfollowUser)
+	mux.HandleFunc("PUT /api/users/privacy", cfg.updatePrivacy)
+	mux.HandleFunc("PUT /api/users/profile", cfg.updateProfile)
+	mux.HandleFunc("GET /api/users/{handle}", cfg.getProfile)
+	mux.HandleFunc("POST /api/users/{id}/block", cfg.blockUser)
+	mux.HandleFunc("DELETE /api/users/{id}/block", cfg.unblockUser)
+	mux.HandleFunc("POST /api/users/{id}/mute", cfg.muteUser)
+	mux.HandleFunc("DELETE /api/users/{id}/mute", cfg.unmuteUser)
+	mux.HandleFunc("GET /api/blocks", cfg.getBlocks)
+	mux.HandleFunc("GET /api/mutes", cfg.getMutes)
+	mux.HandleFunc("GET /api/follow-requests", cfg.getFollowRequests)
+	mux.HandleFunc("POST /api/follow-requests/{id}/approve", cfg.approveFollowRequest)
+	mux.HandleFunc("DELETE /api/follow-requests/{id}", cfg.rejectFollowRequest)
+	mux.HandleFunc("POST /api/conversations", cfg.createConversation)
+	mux.HandleFunc("GET /api/conversations", cfg.getConversations)
+	mux.HandleFunc("GET /api/conversations/{id}/messages", cfg.getMessages)
+	mux.HandleFunc("POST /api/conversations/{id}/messages", cfg.sendMessage)
+	mux.HandleFunc("POST /api/conversations/{id}/read", cfg.markConversationRead)
+	mux.HandleFunc("GET /api/notifications", cfg.getNotifications)
+	mux.HandleFunc("POST /api/notifications/read", cfg.markNotificationsRead)
+	mux.HandleFunc("POST /api/notifications/{id}/read", cfg.markNotificationRead)
+	mux.HandleFunc("GET /api/notifications/preferences", cfg.getNotificationPrefs)
+	mux.HandleFunc("PUT /api/notifications/preferences", cfg.updateNotificationPrefs)
+	mux.HandleFunc("POST /api/refresh", cfg.refreshJWT)
+	mux.HandleFunc("POST /api/revoke", cfg.revokeJWT)
+	mux.HandleFunc("POST /api/logout", cfg.logout)
+	mux.HandleFunc("POST /api/logout/all", cfg.logoutEverywhere)
+	mux.HandleFunc("PUT /api/chirps/{id}", cfg.editChirp)
+	mux.HandleFunc("DELETE /api/chirps/{id}", cfg.deleteChirp)
+	mux.HandleFunc("GET /api/entitlements", cfg.getEntitlements)
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.upgradeUser)
+	mux.HandleFunc("POST /api/keys", cfg.createAPIKey)
+	mux.HandleFunc("GET /api/keys", cfg.getAPIKeys)
+	mux.HandleFunc("DELETE /api/keys/{id}", cfg.deleteAPIKey)
+	mux.HandleFunc("POST /api/webhooks", cfg.createWebhook)
+	mux.HandleFunc("GET /api/webhooks", cfg.getWebhooks)
+	mux.HandleFunc("DELETE /api/webhooks/{id}", cfg.deleteWebhook)
+	mux.HandleFunc("GET /api/webhooks/{id}/deliveries", cfg.getWebhookDeliveries)
+	mux.HandleFunc("POST /api/oauth/clients", cfg.createOAuthClient)
+	mux.HandleFunc("GET /api/oauth/clients", cfg.getOAuthClients)
+	mux.HandleFunc("POST /api/oauth/authorize", cfg.authorize)
+	mux.HandleFunc("POST /api/oauth/token", cfg.exchangeToken)
+	mux.HandleFunc("POST /api/oauth/introspect", cfg.introspectToken)
+	mux.HandleFunc("POST /api/oauth/revoke", cfg.revokeOAuthToken)
+	mux.HandleFunc("GET /api/oauth/consents", cfg.getOAuthConsents)
+	mux.HandleFunc("DELETE /api/oauth/consents/{client_id}", cfg.deleteOAuthConsent)
+
+	return mux
+}
+
+// envInt reads an integer environment variable, falling back when it is unset
+// or malformed.
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envIntInRange reads an integer environment variable that must lie within
+// [min, max], falling back when it is unset. Malformed or out of range values
+// are fatal.
+func envIntInRange(name string, fallback int, min int, max int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		log.Fatalf("%s must be an integer from %d to %d, got %q", name, min, max, raw)
+	}
+	return value
+}
+
 func (cfg *apiConfig) middlewareMetricInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg.fileServerHits += 1
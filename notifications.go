@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+// mentionPattern matches "@" followed by a user's handle. The "@" must not
+// follow a word character, so email addresses aren't read as mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_.@])@([A-Za-z0-9_]{3,15})\b`)
+
+// chirpNotifications is registered as a chirp listener so every path that
+// publishes a chirp notifies the author being replied to and anyone mentioned.
+// Nobody is notified about a chirp they aren't allowed to see.
+func (cfg *apiConfig) chirpNotifications(event string, chirp db.Chirp) {
+	if event != db.ChirpCreated {
+		return
+	}
+
+	notifications := []db.Notification{}
+	notified := map[int]bool{}
+	if chirp.ReplyToID != 0 {
+		parent, err := cfg.DB.GetChirp(chirp.ReplyToID)
+		if err == nil {
+			notifications = append(notifications, db.Notification{
+				UserID:  parent.AuthorID,
+				Type:    db.NotificationReply,
+				ActorID: chirp.AuthorID,
+				ChirpID: chirp.ID,
+			})
+			notified[parent.AuthorID] = true
+		}
+	}
+
+	for _, userId := range chirp.MentionedIDs {
+		if notified[userId] {
+			continue
+		}
+		notifications = append(notifications, db.Notification{
+			UserID:  userId,
+			Type:    db.NotificationMention,
+			ActorID: chirp.AuthorID,
+			ChirpID: chirp.ID,
+		})
+		notified[userId] = true
+	}
+
+	visible := []db.Notification{}
+	for _, notification := range notifications {
+		audience, err := cfg.DB.GetAudience(notification.UserID)
+		if err == nil && audience.CanView(chirp) {
+			visible = append(visible, notification)
+		}
+	}
+	if len(visible) == 0 {
+		return
+	}
+	err := cfg.createNotifications(visible)
+	if err != nil {
+		log.Printf("Couldn't create notifications for chirp %d: %s", chirp.ID, err)
+	}
+}
+
+// notify creates a single notification, logging rather than failing the
+// request if it can't be stored.
+func (cfg *apiConfig) notify(notification db.Notification) {
+	err := cfg.createNotifications([]db.Notification{notification})
+	if err != nil {
+		log.Printf("Couldn't create %s notification: %s", notification.Type, err)
+	}
+}
+
+// createNotifications stores notifications and pushes the ones that were
+// created to their recipients' WebSocket connections.
+func (cfg *apiConfig) createNotifications(notifications []db.Notification) error {
+	created, err := cfg.DB.CreateNotifications(notifications)
+	if err != nil {
+		return err
+	}
+	for _, notification := range created {
+		cfg.Notifications.PublishNotification(notification)
+	}
+	return nil
+}
+
+// mentionedUsers returns the ids of existing users mentioned in body,
+// leaving out anyone the author has a block with.
+func (cfg *apiConfig) mentionedUsers(authorId int, body string) []int {
+	userIds := []int{}
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		handle := strings.ToLower(match[1])
+		if seen[handle] {
+			continue
+		}
+		seen[handle] = true
+
+		user, err := cfg.DB.GetUserByHandle(handle)
+		if err != nil {
+			continue
+		}
+		blocked, err := cfg.DB.IsBlocked(authorId, user.ID)
+		if err != nil || blocked {
+			continue
+		}
+		userIds = append(userIds, user.ID)
+	}
+
+	return userIds
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zmahl/chirpy/internal/auth"
+	"github.com/Zmahl/chirpy/internal/db"
+	"github.com/Zmahl/chirpy/internal/entitlements"
+	"github.com/Zmahl/chirpy/internal/linkpreview"
+	"github.com/Zmahl/chirpy/internal/mail"
+	"github.com/Zmahl/chirpy/internal/media"
+	"github.com/Zmahl/chirpy/internal/pubsub"
+	"github.com/Zmahl/chirpy/internal/ratelimit"
+	"github.com/Zmahl/chirpy/internal/validation"
+	"github.com/Zmahl/chirpy/internal/webhooks"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// persistedKeys are fields of stored records that no client should see.
+var persistedKeys = []string{`"password"`, `"refresh_token"`, `"totp_secret"`, `"key_hash"`, `"secret_hash"`}
+
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *recordingMailer) Send(msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// lastToken returns the token at the end of the newest email sent to to.
+func (m *recordingMailer) lastToken(to string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			lines := strings.Split(strings.TrimSpace(m.messages[i].Body), "\n")
+			return lines[len(lines)-1]
+		}
+	}
+	return ""
+}
+
+type testUser struct {
+	ID           int
+	Email        string
+	Password     string
+	Token        string
+	RefreshToken string
+}
+
+// capture is one body a client received.
+type capture struct {
+	label  string
+	viewer *testUser
+	body   []byte
+	// issuesCredentials marks the responses that hand the caller a secret
+	// once, like a login's refresh token or a new TOTP secret
+	issuesCredentials bool
+	// checkKeys is false for the export, which keeps the stored field names
+	// with the credentials blanked out
+	checkKeys bool
+}
+
+type leakTest struct {
+	t        *testing.T
+	cfg      *apiConfig
+	server   *httptest.Server
+	mailer   *recordingMailer
+	mu       sync.Mutex
+	captures []capture
+}
+
+func newLeakTest(t *testing.T) *leakTest {
+	t.Helper()
+
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	hasher, err := auth.NewPasswordHasher(auth.AlgorithmBcrypt)
+	if err != nil {
+		t.Fatalf("NewPasswordHasher: %s", err)
+	}
+	hasher.BcryptCost = bcrypt.MinCost
+	blobs, err := media.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskStore: %s", err)
+	}
+
+	mailer := &recordingMailer{}
+	broker := pubsub.NewBroker(1000, 64)
+	database.AddChirpListener(broker.ChirpListener)
+	cfg := &apiConfig{
+		DB:             database,
+		SecretString:   "test-secret",
+		PolkaKey:       "polka-key",
+		AdminKey:       "admin-key",
+		Mailer:         mailer,
+		Hasher:         hasher,
+		PasswordPolicy: auth.NewPasswordPolicy(8, 72),
+		Plans:          entitlements.Default(),
+		ChirpLimiter:   ratelimit.New(),
+		Webhooks:       webhooks.NewDispatcher(database),
+		Broker:         broker,
+		Notifications:  pubsub.NewBroker(100, 16),
+		Blobs:          blobs,
+		MaxMediaBytes:  5 << 20,
+		PreviewFetcher: linkpreview.NewFetcher(),
+		PreviewQueue:   make(chan string, 256),
+		ChirpValidator: validation.NewChirpValidator(),
+	}
+	database.AddChirpListener(cfg.chirpNotifications)
+
+	server := httptest.NewServer(cfg.routes())
+	t.Cleanup(server.Close)
+
+	return &leakTest{
+		t:      t,
+		cfg:    cfg,
+		server: server,
+		mailer: mailer,
+	}
+}
+
+func (lt *leakTest) record(c capture) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.captures = append(lt.captures, c)
+}
+
+// request sends body as viewer, or anonymously if viewer is nil, and records
+// the response.
+func (lt *leakTest) request(viewer *testUser, method string, path string, body string) []byte {
+	lt.t.Helper()
+	return lt.requestWith(viewer, method, path, body, nil)
+}
+
+func (lt *leakTest) requestWith(viewer *testUser, method string, path string, body string, header http.Header) []byte {
+	lt.t.Helper()
+
+	req, err := http.NewRequest(method, lt.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		lt.t.Fatalf("%s %s: %s", method, path, err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if viewer != nil && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+viewer.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		lt.t.Fatalf("%s %s: %s", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		lt.t.Fatalf("%s %s: %s", method, path, err)
+	}
+	if resp.StatusCode >= 400 {
+		lt.t.Fatalf("%s %s: status %d: %s", method, path, resp.StatusCode, data)
+	}
+
+	lt.record(capture{
+		label:             method + " " + path,
+		viewer:            viewer,
+		body:              data,
+		issuesCredentials: path == "/api/login" || path == "/api/mfa/totp/enroll",
+		checkKeys:         true,
+	})
+	return data
+}
+
+func decode(t *testing.T, data []byte, v interface{}) {
+	t.Helper()
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		t.Fatalf("decoding %s: %s", data, err)
+	}
+}
+
+// signUp creates a verified user and logs them in.
+func (lt *leakTest) signUp(name string) *testUser {
+	lt.t.Helper()
+
+	user := &testUser{Email: name + "@example.com", Password: "correct horse " + name}
+	created := PrivateUser{}
+	decode(lt.t, lt.request(user, "POST", "/api/users", fmt.Sprintf(`{"email":%q,"password":%q,"handle":%q}`, user.Email, user.Password, name)), &created)
+	user.ID = created.ID
+
+	lt.request(user, "POST", "/api/users/verify", fmt.Sprintf(`{"token":%q}`, lt.mailer.lastToken(user.Email)))
+
+	login := LoginResponse{}
+	decode(lt.t, lt.request(user, "POST", "/api/login", fmt.Sprintf(`{"email":%q,"password":%q}`, user.Email, user.Password)), &login)
+	user.Token = login.Token
+	user.RefreshToken = login.RefreshToken
+	return user
+}
+
+// streamSSE records every data line of an anonymous event stream until the
+// test ends.
+func (lt *leakTest) streamSSE() {
+	lt.t.Helper()
+
+	resp, err := http.Get(lt.server.URL + "/api/chirps/stream")
+	if err != nil {
+		lt.t.Fatalf("opening stream: %s", err)
+	}
+	lt.t.Cleanup(func() { resp.Body.Close() })
+
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, found := strings.CutPrefix(scanner.Text(), "data: "); found {
+				lt.record(capture{label: "SSE /api/chirps/stream", body: []byte(data), checkKeys: true})
+			}
+		}
+	}()
+}
+
+// streamWebSocket subscribes viewer to the timeline and their notifications
+// and records every frame until the test ends.
+func (lt *leakTest) streamWebSocket(viewer *testUser) {
+	lt.t.Helper()
+
+	dialer := websocket.Dialer{Subprotocols: []string{wsProtocol, wsBearerProtocol + viewer.Token}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(lt.server.URL, "http")+"/api/ws", nil)
+	if err != nil {
+		lt.t.Fatalf("dialing WebSocket: %s", err)
+	}
+	lt.t.Cleanup(func() { conn.Close() })
+
+	for _, topic := range []string{"timeline", wsTopicNotifications} {
+		err = conn.WriteJSON(wsClientMessage{Type: "subscribe", Topic: topic})
+		if err != nil {
+			lt.t.Fatalf("subscribing to %s: %s", topic, err)
+		}
+		msg := wsServerMessage{}
+		err = conn.ReadJSON(&msg)
+		if err != nil || msg.Type != "subscribed" {
+			lt.t.Fatalf("subscribing to %s: got %+v, %v", topic, msg, err)
+		}
+	}
+
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			lt.record(capture{label: "WS /api/ws", viewer: viewer, body: data, checkKeys: true})
+		}
+	}()
+}
+
+// TestResponsesDoNotLeakSecrets walks through the API as several users and
+// checks that nothing a client receives carries stored credentials or
+// another user's email address.
+func TestResponsesDoNotLeakSecrets(t *testing.T) {
+	lt := newLeakTest(t)
+	alice := lt.signUp("alice")
+	bob := lt.signUp("bob")
+	carol := lt.signUp("carol")
+	admin := http.Header{"Authorization": {"ApiKey admin-key"}}
+
+	lt.streamSSE()
+	lt.streamWebSocket(alice)
+
+	// Relationships
+	lt.request(carol, "PUT", "/api/users/privacy", `{"is_private":true}`)
+	lt.request(alice, "POST", fmt.Sprintf("/api/users/%d/follow", carol.ID), "")
+	lt.request(carol, "GET", "/api/follow-requests", "")
+	lt.request(alice, "POST", fmt.Sprintf("/api/users/%d/follow", bob.ID), "")
+	lt.request(bob, "POST", fmt.Sprintf("/api/users/%d/block", carol.ID), "")
+	lt.request(bob, "GET", "/api/blocks", "")
+	lt.request(alice, "POST", fmt.Sprintf("/api/users/%d/mute", carol.ID), "")
+	lt.request(alice, "GET", "/api/mutes", "")
+
+	// Webhooks, registered before anything is published so they get events
+	lt.request(alice, "POST", "/api/webhooks", `{"url":"https://93.184.216.34/alice","events":["chirp.created","user.upgraded"]}`)
+	lt.requestWith(nil, "POST", "/api/webhooks", `{"url":"https://93.184.216.34/admin","events":["chirp.created","chirp.deleted","user.upgraded"]}`, admin)
+
+	// Chirps and notifications
+	chirp := Chirp{}
+	decode(t, lt.request(bob, "POST", "/api/chirps", `{"body":"hello @alice"}`), &chirp)
+	lt.request(alice, "POST", "/api/chirps", `{"body":"hi @bob"}`)
+	lt.request(nil, "GET", "/api/chirps", "")
+	lt.request(nil, "GET", "/api/chirps/"+strconv.Itoa(chirp.ID), "")
+	lt.request(alice, "GET", fmt.Sprintf("/api/chirps?author_id=%d", bob.ID), "")
+	lt.request(nil, "GET", "/api/users/alice", "")
+	lt.request(alice, "GET", "/api/users/bob", "")
+	lt.request(alice, "GET", "/api/notifications", "")
+	lt.request(alice, "GET", "/api/notifications/preferences", "")
+
+	// Direct messages
+	conversation := Conversation{}
+	decode(t, lt.request(alice, "POST", "/api/conversations", fmt.Sprintf(`{"participant_ids":[%d]}`, bob.ID)), &conversation)
+	lt.request(bob, "POST", "/api/conversations/"+conversation.ID+"/messages", `{"body":"hey alice"}`)
+	lt.request(alice, "GET", "/api/conversations", "")
+	lt.request(alice, "GET", "/api/conversations/"+conversation.ID+"/messages", "")
+
+	// Account and credentials
+	lt.request(alice, "PUT", "/api/users/profile", `{"bio":"hello"}`)
+	lt.request(alice, "POST", "/api/mfa/totp/enroll", "")
+	lt.request(alice, "POST", "/api/keys", `{"name":"ci","scopes":["chirps:read"]}`)
+	lt.request(alice, "GET", "/api/keys", "")
+	lt.request(alice, "POST", "/api/oauth/clients", `{"name":"app","redirect_uris":["https://app.example.com/callback"],"confidential":true}`)
+	lt.request(alice, "GET", "/api/oauth/clients", "")
+	lt.request(alice, "GET", "/api/entitlements", "")
+	lt.requestWith(alice, "POST", "/api/refresh", "", http.Header{"Authorization": {"Bearer " + alice.RefreshToken}})
+
+	// Chirpy Red
+	polka := fmt.Sprintf(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":%d,"plan":"chirpy_red"}}`, alice.ID)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	lt.requestWith(nil, "POST", "/api/polka/webhooks", polka, http.Header{
+		"Polka-Timestamp": {timestamp},
+		"Polka-Signature": {auth.SignWebhook("polka-key", timestamp, []byte(polka))},
+	})
+
+	// Queued webhook deliveries are sent to third parties as stored
+	endpoints := []WebhookEndpoint{}
+	decode(t, lt.request(alice, "GET", "/api/webhooks", ""), &endpoints)
+	lt.request(alice, "GET", "/api/webhooks/"+endpoints[0].ID+"/deliveries", "")
+	adminEndpoints := []WebhookEndpoint{}
+	decode(t, lt.requestWith(nil, "GET", "/api/webhooks", "", admin), &adminEndpoints)
+	owners := map[string]*testUser{endpoints[0].ID: alice, adminEndpoints[0].ID: nil}
+	for id, owner := range owners {
+		deliveries, err := lt.cfg.DB.GetWebhookDeliveries(id)
+		if err != nil {
+			t.Fatalf("GetWebhookDeliveries: %s", err)
+		}
+		if len(deliveries) == 0 {
+			t.Fatalf("no deliveries queued for endpoint %s", id)
+		}
+		for _, delivery := range deliveries {
+			lt.record(capture{label: "webhook " + delivery.Event, viewer: owner, body: delivery.Payload, checkKeys: true})
+		}
+	}
+
+	// The export keeps stored field names, so only values are checked
+	export := lt.request(alice, "GET", "/api/users/export", "")
+	archive, err := zip.NewReader(bytes.NewReader(export), int64(len(export)))
+	if err != nil {
+		t.Fatalf("reading export: %s", err)
+	}
+	file, err := archive.Open("data.json")
+	if err != nil {
+		t.Fatalf("opening data.json: %s", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("reading data.json: %s", err)
+	}
+	lt.record(capture{label: "export data.json", viewer: alice, body: data})
+
+	// Give the streams time to receive the events published above
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && !(lt.captured("SSE ") && lt.captured("WS ")) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !lt.captured("SSE ") || !lt.captured("WS ") {
+		t.Fatal("streams did not receive any events")
+	}
+
+	lt.scan([]*testUser{alice, bob, carol})
+}
+
+func (lt *leakTest) captured(prefix string) bool {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for _, c := range lt.captures {
+		if strings.HasPrefix(c.label, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// scan checks every captured body against the secrets now in the database.
+func (lt *leakTest) scan(users []*testUser) {
+	lt.t.Helper()
+
+	secrets := []string{}
+	totpSecrets := []string{}
+	for _, user := range users {
+		stored, err := lt.cfg.DB.GetUserByID(user.ID)
+		if err != nil {
+			lt.t.Fatalf("GetUserByID: %s", err)
+		}
+		secrets = append(secrets, string(stored.Password), base64.StdEncoding.EncodeToString(stored.Password))
+		if stored.TOTPSecret != "" {
+			totpSecrets = append(totpSecrets, stored.TOTPSecret)
+		}
+
+		keys, err := lt.cfg.DB.GetAPIKeysByUser(user.ID)
+		if err != nil {
+			lt.t.Fatalf("GetAPIKeysByUser: %s", err)
+		}
+		for _, key := range keys {
+			secrets = append(secrets, key.KeyHash)
+		}
+		clients, err := lt.cfg.DB.GetOAuthClientsByOwner(user.ID)
+		if err != nil {
+			lt.t.Fatalf("GetOAuthClientsByOwner: %s", err)
+		}
+		for _, client := range clients {
+			secrets = append(secrets, client.SecretHash)
+		}
+	}
+	if len(totpSecrets) == 0 {
+		lt.t.Fatal("no TOTP secret was stored")
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for _, c := range lt.captures {
+		if c.checkKeys {
+			for _, key := range persistedKeys {
+				if key == `"refresh_token"` && c.issuesCredentials {
+					continue
+				}
+				if bytes.Contains(c.body, []byte(key)) {
+					lt.t.Errorf("%s: body has %s: %s", c.label, key, c.body)
+				}
+			}
+		}
+		for _, secret := range secrets {
+			if secret != "" && bytes.Contains(c.body, []byte(secret)) {
+				lt.t.Errorf("%s: body has a stored hash: %s", c.label, c.body)
+			}
+		}
+		if !c.issuesCredentials {
+			for _, secret := range totpSecrets {
+				if bytes.Contains(c.body, []byte(secret)) {
+					lt.t.Errorf("%s: body has a TOTP secret: %s", c.label, c.body)
+				}
+			}
+		}
+		for _, user := range users {
+			if user != c.viewer && bytes.Contains(bytes.ToLower(c.body), []byte(user.Email)) {
+				lt.t.Errorf("%s: %v can see %s: %s", c.label, viewerName(c.viewer), user.Email, c.body)
+			}
+		}
+	}
+}
+
+func viewerName(viewer *testUser) string {
+	if viewer == nil {
+		return "an anonymous client"
+	}
+	return viewer.Email
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// expireSubscriptions periodically removes Chirpy Red from users whose paid
+// period has ended without a renewal.
+func (cfg *apiConfig) expireSubscriptions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		expired, err := cfg.DB.ExpireSubscriptions(time.Now().UTC())
+		if err != nil {
+			log.Printf("Could not expire subscriptions: %s", err)
+		} else if expired > 0 {
+			log.Printf("Expired %d Chirpy Red subscriptions", expired)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import "github.com/Zmahl/chirpy/internal/db"
+
+// Users have three representations. db.User is the persisted record and
+// holds secrets such as the password hash, so it is never sent to clients.
+// PrivateUser is what the account owner sees about themselves, and Profile
+// and Author are what everyone else sees.
+
+// PrivateUser is the account as its owner sees it.
+type PrivateUser struct {
+	ID          int    `json:"id"`
+	Email       string `json:"email"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	IsRed       bool   `json:"is_chirpy_red"`
+	IsVerified  bool   `json:"is_verified"`
+	IsPrivate   bool   `json:"is_private"`
+	MFAEnabled  bool   `json:"mfa_enabled"`
+}
+
+// LoginResponse is returned once per login with freshly issued tokens.
+type LoginResponse struct {
+	PrivateUser
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func privateUserResponse(user db.User) PrivateUser {
+	return PrivateUser{
+		ID:          user.ID,
+		Email:       user.Email,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   avatarURL(user),
+		IsRed:       user.IsRed,
+		IsVerified:  user.IsVerified,
+		IsPrivate:   user.IsPrivate,
+		MFAEnabled:  user.TOTPEnabled,
+	}
+}
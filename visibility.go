@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/Zmahl/chirpy/internal/db"
+)
+
+var chirpVisibilities = map[string]bool{
+	db.ChirpVisibilityPublic:    true,
+	db.ChirpVisibilityFollowers: true,
+	db.ChirpVisibilityMentioned: true,
+}
+
+// viewerAudience returns what the caller may see. Requests without valid
+// credentials get the anonymous audience rather than an error, since chirps
+// are readable without logging in.
+func (cfg *apiConfig) viewerAudience(r *http.Request) (db.Audience, error) {
+	viewerId, _, err := cfg.authenticateScoped(r, scopeChirpsRead)
+	if err != nil {
+		viewerId = 0
+	}
+	return cfg.DB.GetAudience(viewerId)
+}
+
+// liveAudience is an audience for long-lived streams. Follows can change
+// while a stream is open, so streams refresh it periodically.
+type liveAudience struct {
+	db       *db.DB
+	mu       *sync.RWMutex
+	audience db.Audience
+}
+
+func (cfg *apiConfig) newLiveAudience(viewerId int) (*liveAudience, error) {
+	audience, err := cfg.DB.GetAudience(viewerId)
+	if err != nil {
+		return nil, err
+	}
+	return &liveAudience{
+		db:       cfg.DB,
+		mu:       &sync.RWMutex{},
+		audience: audience,
+	}, nil
+}
+
+func (live *liveAudience) CanView(chirp db.Chirp) bool {
+	live.mu.RLock()
+	defer live.mu.RUnlock()
+
+	return live.audience.CanView(chirp)
+}
+
+func (live *liveAudience) Hides(chirp db.Chirp) bool {
+	live.mu.RLock()
+	defer live.mu.RUnlock()
+
+	return live.audience.Hides(chirp)
+}
+
+func (live *liveAudience) Refresh() {
+	live.mu.RLock()
+	viewerId := live.audience.ViewerID
+	live.mu.RUnlock()
+
+	audience, err := live.db.GetAudience(viewerId)
+	if err != nil {
+		log.Printf("Couldn't refresh audience for user %d: %s", viewerId, err)
+		return
+	}
+
+	live.mu.Lock()
+	live.audience = audience
+	live.mu.Unlock()
+}